@@ -0,0 +1,57 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+// StatsSource - camera session with statistics, ex. *rtsp.Client
+type StatsSource interface {
+	Stats() rtsp.ClientStats
+}
+
+type StreamMetrics struct {
+	Name      string `json:"name,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	rtsp.ClientStats
+}
+
+// Streams - registry of camera sessions to collect metrics from
+type Streams struct {
+	mu      sync.Mutex
+	sources map[string]StatsSource
+}
+
+func NewStreams() *Streams {
+	return &Streams{sources: map[string]StatsSource{}}
+}
+
+func (s *Streams) Add(name string, source StatsSource) {
+	s.mu.Lock()
+	s.sources[name] = source
+	s.mu.Unlock()
+}
+
+func (s *Streams) Remove(name string) {
+	s.mu.Lock()
+	delete(s.sources, name)
+	s.mu.Unlock()
+}
+
+// GetStreamMetrics returns snapshot of all sessions sorted by name
+func (s *Streams) GetStreamMetrics() []StreamMetrics {
+	timeStamp := time.Now().UnixMilli()
+
+	s.mu.Lock()
+	metrics := make([]StreamMetrics, 0, len(s.sources))
+	for name, source := range s.sources {
+		metrics = append(metrics, StreamMetrics{Name: name, Timestamp: timeStamp, ClientStats: source.Stats()})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics
+}
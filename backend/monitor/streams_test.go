@@ -0,0 +1,35 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+type fakeSource rtsp.ClientStats
+
+func (f fakeSource) Stats() rtsp.ClientStats {
+	return rtsp.ClientStats(f)
+}
+
+func TestGetStreamMetrics(t *testing.T) {
+	streams := NewStreams()
+	streams.Add("camera2", fakeSource{URL: "rtsp://camera2/stream", State: rtsp.StatePlay})
+	streams.Add("camera1", fakeSource{URL: "rtsp://camera1/stream"})
+	streams.Add("camera3", fakeSource{})
+	streams.Remove("camera3")
+
+	metrics := streams.GetStreamMetrics()
+	if len(metrics) != 2 {
+		t.Fatalf("GetStreamMetrics() len = %d, want 2", len(metrics))
+	}
+	if metrics[0].Name != "camera1" || metrics[1].Name != "camera2" {
+		t.Errorf("GetStreamMetrics() names = %s, %s", metrics[0].Name, metrics[1].Name)
+	}
+	if metrics[1].URL != "rtsp://camera2/stream" || metrics[1].State != rtsp.StatePlay {
+		t.Errorf("GetStreamMetrics() = %+v", metrics[1])
+	}
+	if metrics[0].Timestamp == 0 {
+		t.Error("GetStreamMetrics() timestamp is zero")
+	}
+}
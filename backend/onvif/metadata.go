@@ -0,0 +1,220 @@
+package onvif
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+// MaxDocumentSize - limit for one reassembled metadata document
+const MaxDocumentSize = 1024 * 1024
+
+var ErrDocumentSize = errors.New("onvif: metadata document too big")
+
+// MetadataStream - parsed tt:MetadataStream document
+type MetadataStream struct {
+	Frames []Frame `xml:"VideoAnalytics>Frame"`
+	Events []Event `xml:"Event>NotificationMessage"`
+}
+
+// Frame - objects detected by video analytics on one video frame
+type Frame struct {
+	UtcTime time.Time `xml:"UtcTime,attr"`
+	Objects []Object  `xml:"Object"`
+}
+
+type Object struct {
+	ID              string `xml:"ObjectId,attr"`
+	BoundingBox     *Rect  `xml:"Appearance>Shape>BoundingBox"`
+	CenterOfGravity *Point `xml:"Appearance>Shape>CenterOfGravity"`
+
+	// Classes from tt:Type (ONVIF 1.0) or tt:ClassCandidate (ONVIF 2.0)
+	Classes []Class `xml:"-"`
+}
+
+type Class struct {
+	Type       string  `xml:"Type"`
+	Likelihood float64 `xml:"Likelihood"`
+}
+
+type classType struct {
+	Type       string  `xml:",chardata"`
+	Likelihood float64 `xml:"Likelihood,attr"`
+}
+
+// Rect - bounding box in normalized or pixel coordinates, as camera sends them
+type Rect struct {
+	Left   float64 `xml:"left,attr"`
+	Top    float64 `xml:"top,attr"`
+	Right  float64 `xml:"right,attr"`
+	Bottom float64 `xml:"bottom,attr"`
+}
+
+type Point struct {
+	X float64 `xml:"x,attr"`
+	Y float64 `xml:"y,attr"`
+}
+
+// Event - wsnt:NotificationMessage from the stream
+type Event struct {
+	Topic     string
+	Time      time.Time
+	Operation string            // Initialized, Changed or Deleted
+	Source    map[string]string // ex. VideoSourceConfigurationToken, Rule
+	Data      map[string]string // ex. IsMotion, State, ObjectId
+}
+
+// Motion returns state of motion detector events:
+// tns1:RuleEngine/CellMotionDetector/Motion and tns1:VideoSource/MotionAlarm
+func (e *Event) Motion() (active, ok bool) {
+	switch {
+	case strings.HasSuffix(e.Topic, "CellMotionDetector/Motion"):
+		return e.Data["IsMotion"] == "true", true
+	case strings.HasSuffix(e.Topic, "VideoSource/MotionAlarm"):
+		return e.Data["State"] == "true", true
+	}
+	return false, false
+}
+
+// LineCrossing returns object of tns1:RuleEngine/LineDetector/Crossed event
+func (e *Event) LineCrossing() (objectID string, ok bool) {
+	if strings.HasSuffix(e.Topic, "LineDetector/Crossed") {
+		return e.Data["ObjectId"], true
+	}
+	return "", false
+}
+
+type simpleItem struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:"Value,attr"`
+}
+
+type notificationMessage struct {
+	Topic   string `xml:"Topic"`
+	Message struct {
+		UtcTime           time.Time    `xml:"UtcTime,attr"`
+		PropertyOperation string       `xml:"PropertyOperation,attr"`
+		Source            []simpleItem `xml:"Source>SimpleItem"`
+		Data              []simpleItem `xml:"Data>SimpleItem"`
+	} `xml:"Message>Message"`
+}
+
+func (e *Event) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var msg notificationMessage
+	if err := d.DecodeElement(&msg, &start); err != nil {
+		return err
+	}
+
+	e.Topic = strings.TrimSpace(msg.Topic)
+	e.Time = msg.Message.UtcTime
+	e.Operation = msg.Message.PropertyOperation
+	e.Source = simpleItems(msg.Message.Source)
+	e.Data = simpleItems(msg.Message.Data)
+	return nil
+}
+
+func (o *Object) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type object Object // without UnmarshalXML method
+
+	var v struct {
+		object
+		Types      []classType `xml:"Appearance>Class>Type"`
+		Candidates []Class     `xml:"Appearance>Class>ClassCandidate"`
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+
+	*o = Object(v.object)
+	for _, t := range v.Types {
+		o.Classes = append(o.Classes, Class{Type: strings.TrimSpace(t.Type), Likelihood: t.Likelihood})
+	}
+	o.Classes = append(o.Classes, v.Candidates...)
+	return nil
+}
+
+func simpleItems(items []simpleItem) map[string]string {
+	if len(items) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(items))
+	for _, item := range items {
+		m[item.Name] = item.Value
+	}
+	return m
+}
+
+// ParseMetadataStream parses one tt:MetadataStream document
+func ParseMetadataStream(b []byte) (*MetadataStream, error) {
+	ms := &MetadataStream{}
+	if err := xml.Unmarshal(bytes.TrimSpace(b), ms); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Depacketizer reassembles XML document from RTP packets, the last packet
+// of document has marker bit. Document with lost packets is dropped.
+type Depacketizer struct {
+	buf     []byte
+	seq     uint16
+	started bool
+	broken  bool
+}
+
+// Push adds packet and returns complete document on marker bit
+func (d *Depacketizer) Push(packet *rtp.Packet) ([]byte, error) {
+	// after any loss it is unknown where current document starts
+	if d.started && packet.SequenceNumber != d.seq+1 {
+		d.broken = true
+	}
+	d.seq = packet.SequenceNumber
+	d.started = true
+
+	if !d.broken {
+		if len(d.buf)+len(packet.Payload) > MaxDocumentSize {
+			d.buf = d.buf[:0]
+			d.broken = true
+			return nil, ErrDocumentSize
+		}
+		d.buf = append(d.buf, packet.Payload...)
+	}
+
+	if !packet.Marker {
+		return nil, nil
+	}
+
+	b := d.buf
+	broken := d.broken
+
+	d.buf = nil
+	d.broken = false
+
+	if broken || len(b) == 0 {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// NewHandler returns handler for metadata Receiver, which calls fn for each
+// parsed document. Broken documents are skipped.
+func NewHandler(fn func(ms *MetadataStream)) *rtsp.Handler {
+	var d Depacketizer
+
+	return &rtsp.Handler{
+		OnRTP: func(packet *rtp.Packet) {
+			b, err := d.Push(packet)
+			if b == nil || err != nil {
+				return
+			}
+			if ms, err := ParseMetadataStream(b); err == nil {
+				fn(ms)
+			}
+		},
+	}
+}
@@ -0,0 +1,134 @@
+package onvif
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+const testMetadata = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tns1="http://www.onvif.org/ver10/topics">
+<tt:VideoAnalytics>
+<tt:Frame UtcTime="2024-05-01T10:20:30.123Z">
+<tt:Object ObjectId="12">
+<tt:Appearance>
+<tt:Shape>
+<tt:BoundingBox left="0.1" top="0.2" right="0.3" bottom="0.4"/>
+<tt:CenterOfGravity x="0.2" y="0.3"/>
+</tt:Shape>
+<tt:Class><tt:Type Likelihood="0.9">Human</tt:Type></tt:Class>
+</tt:Appearance>
+</tt:Object>
+<tt:Object ObjectId="13">
+<tt:Appearance>
+<tt:Class><tt:ClassCandidate><tt:Type>Vehicle</tt:Type><tt:Likelihood>0.75</tt:Likelihood></tt:ClassCandidate></tt:Class>
+</tt:Appearance>
+</tt:Object>
+</tt:Frame>
+</tt:VideoAnalytics>
+<tt:Event>
+<wsnt:NotificationMessage>
+<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:RuleEngine/CellMotionDetector/Motion</wsnt:Topic>
+<wsnt:Message>
+<tt:Message UtcTime="2024-05-01T10:20:30Z" PropertyOperation="Changed">
+<tt:Source>
+<tt:SimpleItem Name="VideoSourceConfigurationToken" Value="VideoSource_1"/>
+<tt:SimpleItem Name="Rule" Value="MyMotionDetectorRule"/>
+</tt:Source>
+<tt:Data><tt:SimpleItem Name="IsMotion" Value="true"/></tt:Data>
+</tt:Message>
+</wsnt:Message>
+</wsnt:NotificationMessage>
+<wsnt:NotificationMessage>
+<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:RuleEngine/LineDetector/Crossed</wsnt:Topic>
+<wsnt:Message>
+<tt:Message UtcTime="2024-05-01T10:20:31Z">
+<tt:Data><tt:SimpleItem Name="ObjectId" Value="12"/></tt:Data>
+</tt:Message>
+</wsnt:Message>
+</wsnt:NotificationMessage>
+</tt:Event>
+</tt:MetadataStream>`
+
+func TestParseMetadataStream(t *testing.T) {
+	ms, err := ParseMetadataStream([]byte(testMetadata))
+	require.NoError(t, err)
+
+	require.Len(t, ms.Frames, 1)
+	frame := ms.Frames[0]
+	require.Equal(t, time.Date(2024, 5, 1, 10, 20, 30, 123_000_000, time.UTC), frame.UtcTime)
+	require.Equal(t, []Object{
+		{
+			ID:              "12",
+			BoundingBox:     &Rect{Left: 0.1, Top: 0.2, Right: 0.3, Bottom: 0.4},
+			CenterOfGravity: &Point{X: 0.2, Y: 0.3},
+			Classes:         []Class{{Type: "Human", Likelihood: 0.9}},
+		},
+		{
+			ID:      "13",
+			Classes: []Class{{Type: "Vehicle", Likelihood: 0.75}},
+		},
+	}, frame.Objects)
+
+	require.Len(t, ms.Events, 2)
+
+	motion := ms.Events[0]
+	require.Equal(t, "tns1:RuleEngine/CellMotionDetector/Motion", motion.Topic)
+	require.Equal(t, "Changed", motion.Operation)
+	require.Equal(t, "VideoSource_1", motion.Source["VideoSourceConfigurationToken"])
+	active, ok := motion.Motion()
+	require.True(t, ok)
+	require.True(t, active)
+	_, ok = motion.LineCrossing()
+	require.False(t, ok)
+
+	objectID, ok := ms.Events[1].LineCrossing()
+	require.True(t, ok)
+	require.Equal(t, "12", objectID)
+
+	_, err = ParseMetadataStream([]byte("<tt:MetadataStream>"))
+	require.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	medias, err := rtsp.UnmarshalSDP([]byte("v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+		"m=application 0 RTP/AVP 107\r\na=rtpmap:107 vnd.onvif.metadata/90000\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, rtsp.KindApp, medias[0].Kind)
+	require.Equal(t, rtsp.CodecONVIF, medias[0].Codecs[0].Name)
+
+	receiver := rtsp.NewReceiver(medias[0], 0)
+
+	received := make(chan *MetadataStream, 10)
+	handler := NewHandler(func(ms *MetadataStream) { received <- ms })
+	handler.Policy = rtsp.Block
+	receiver.AddHandler(handler)
+
+	var seq uint16
+	send := func(doc string, lose int) {
+		for i := 0; i < len(doc); i += 500 {
+			seq++
+			if i/500 == lose {
+				continue
+			}
+			receiver.WriteRTP(&rtp.Packet{
+				Header:  rtp.Header{SequenceNumber: seq, Marker: i+500 >= len(doc)},
+				Payload: []byte(doc[i:min(i+500, len(doc))]),
+			})
+		}
+	}
+
+	send(testMetadata, -1)
+	send(testMetadata, 1) // broken document is skipped
+	send(testMetadata, -1)
+
+	receiver.RemoveHandler(handler)
+	<-handler.Done()
+
+	require.Len(t, received, 2)
+	ms := <-received
+	require.Len(t, ms.Events, 2)
+}
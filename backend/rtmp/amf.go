@@ -0,0 +1,215 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// AMF0 types https://rtmp.veriskope.com/pdf/amf0-file-format-specification.pdf
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0A
+	amfDate        = 0x0B
+	amfLongString  = 0x0C
+)
+
+var ErrAMF = errors.New("rtmp: wrong AMF data")
+
+// EncodeAMF encodes float64, int, bool, string, map[string]any, []any and nil values
+func EncodeAMF(values ...any) []byte {
+	var b []byte
+	for _, v := range values {
+		b = appendAMF(b, v)
+	}
+	return b
+}
+
+func appendAMF(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case float64:
+		b = append(b, amfNumber)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+	case int:
+		return appendAMF(b, float64(v))
+	case uint32:
+		return appendAMF(b, float64(v))
+	case bool:
+		if v {
+			return append(b, amfBoolean, 1)
+		}
+		return append(b, amfBoolean, 0)
+	case string:
+		if len(v) > math.MaxUint16 {
+			b = append(b, amfLongString)
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+			return append(b, v...)
+		}
+		b = append(b, amfString)
+		return appendAMFKey(b, v)
+	case map[string]any:
+		b = append(b, amfObject)
+
+		// stable order of properties
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			b = appendAMFKey(b, key)
+			b = appendAMF(b, v[key])
+		}
+		return append(b, 0, 0, amfObjectEnd)
+	case []any:
+		b = append(b, amfStrictArray)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+		for _, item := range v {
+			b = appendAMF(b, item)
+		}
+		return b
+	}
+
+	return append(b, amfNull)
+}
+
+func appendAMFKey(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// DecodeAMF decodes all values: numbers as float64, objects and ECMA arrays
+// as map[string]any, null and undefined as nil
+func DecodeAMF(b []byte) ([]any, error) {
+	d := amfDecoder{b: b}
+
+	var values []any
+	for len(d.b) > 0 {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+type amfDecoder struct {
+	b []byte
+}
+
+func (d *amfDecoder) next(n int) ([]byte, error) {
+	if len(d.b) < n {
+		return nil, ErrAMF
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+func (d *amfDecoder) value() (any, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b[0] {
+	case amfNumber:
+		if b, err = d.next(8); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case amfBoolean:
+		if b, err = d.next(1); err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case amfString:
+		return d.key()
+	case amfLongString:
+		if b, err = d.next(4); err != nil {
+			return nil, err
+		}
+		if b, err = d.next(int(binary.BigEndian.Uint32(b))); err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case amfObject:
+		return d.object()
+	case amfECMAArray:
+		// approximate count is useless, array ends like an object
+		if _, err = d.next(4); err != nil {
+			return nil, err
+		}
+		return d.object()
+	case amfStrictArray:
+		if b, err = d.next(4); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(b)
+		if int(n) > len(d.b) {
+			return nil, ErrAMF
+		}
+		items := make([]any, 0, n)
+		for i := uint32(0); i < n; i++ {
+			item, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case amfDate:
+		// 8 bytes milliseconds + 2 bytes timezone
+		if b, err = d.next(10); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case amfNull, amfUndefined:
+		return nil, nil
+	}
+
+	return nil, ErrAMF
+}
+
+func (d *amfDecoder) key() (string, error) {
+	b, err := d.next(2)
+	if err != nil {
+		return "", err
+	}
+	if b, err = d.next(int(binary.BigEndian.Uint16(b))); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *amfDecoder) object() (map[string]any, error) {
+	obj := map[string]any{}
+	for {
+		key, err := d.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "" {
+			if len(d.b) > 0 && d.b[0] == amfObjectEnd {
+				d.b = d.b[1:]
+				return obj, nil
+			}
+			return nil, ErrAMF
+		}
+
+		if obj[key], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAMF(t *testing.T) {
+	b := EncodeAMF("connect", 1, map[string]any{
+		"app":  "live",
+		"fpad": false,
+		"info": map[string]any{"level": "status"},
+	}, nil, []any{1.5, "a"})
+
+	values, err := DecodeAMF(b)
+	require.NoError(t, err)
+	require.Equal(t, []any{
+		"connect", 1.0, map[string]any{
+			"app":  "live",
+			"fpad": false,
+			"info": map[string]any{"level": "status"},
+		}, nil, []any{1.5, "a"},
+	}, values)
+
+	// ECMA array from onMetaData
+	b = EncodeAMF("onMetaData")
+	b = append(b, amfECMAArray, 0, 0, 0, 1, 0, 5)
+	b = append(b, "width"...)
+	b = append(b, EncodeAMF(1920)...)
+	b = append(b, 0, 0, amfObjectEnd)
+
+	values, err = DecodeAMF(b)
+	require.NoError(t, err)
+	require.Equal(t, []any{"onMetaData", map[string]any{"width": 1920.0}}, values)
+
+	_, err = DecodeAMF([]byte{amfString, 0, 10, 'a'})
+	require.ErrorIs(t, err, ErrAMF)
+}
@@ -0,0 +1,274 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Message types https://rtmp.veriskope.com/docs/spec/
+const (
+	TypeSetChunkSize     = 1
+	TypeAbort            = 2
+	TypeAck              = 3
+	TypeUserControl      = 4
+	TypeWindowAckSize    = 5
+	TypeSetPeerBandwidth = 6
+	TypeAudio            = 8
+	TypeVideo            = 9
+	TypeDataAMF3         = 15
+	TypeCommandAMF3      = 17
+	TypeDataAMF0         = 18
+	TypeCommandAMF0      = 20
+	TypeAggregate        = 22
+)
+
+// User control events
+const (
+	EventStreamBegin  = 0
+	EventStreamEOF    = 1
+	EventSetBufferLen = 3
+	EventPingRequest  = 6
+	EventPingResponse = 7
+)
+
+// Chunk stream IDs for outgoing messages
+const (
+	csidControl = 2
+	csidCommand = 3
+	csidStream  = 8
+)
+
+const (
+	DefaultChunkSize = 128
+	maxChunkSize     = 0xFFFFFF
+	extendedTS       = 0xFFFFFF
+)
+
+var ErrChunkSize = errors.New("rtmp: wrong chunk size")
+
+type Message struct {
+	Type      byte
+	StreamID  uint32
+	Timestamp uint32 // milliseconds
+	Payload   []byte
+}
+
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typ       byte
+	streamID  uint32
+	extended  bool
+	payload   []byte
+}
+
+// chunkReader assembles messages from interleaved chunks
+type chunkReader struct {
+	r       *bufio.Reader
+	size    uint32
+	streams map[uint32]*chunkStream
+	buf     [11]byte
+	read    uint64 // total bytes for acknowledgement
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{
+		r:       bufio.NewReaderSize(r, 64*1024),
+		size:    DefaultChunkSize,
+		streams: map[uint32]*chunkStream{},
+	}
+}
+
+func (r *chunkReader) readFull(n int) ([]byte, error) {
+	b := r.buf[:n]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	r.read += uint64(n)
+	return b, nil
+}
+
+func (r *chunkReader) ReadMessage() (*Message, error) {
+	for {
+		b, err := r.readFull(1)
+		if err != nil {
+			return nil, err
+		}
+
+		format := b[0] >> 6
+		csid := uint32(b[0] & 0x3F)
+
+		switch csid {
+		case 0:
+			if b, err = r.readFull(1); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(b[0])
+		case 1:
+			if b, err = r.readFull(2); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(b[0]) + uint32(b[1])<<8
+		}
+
+		cs := r.streams[csid]
+		if cs == nil {
+			if format != 0 {
+				return nil, errors.New("rtmp: first chunk without full header")
+			}
+			cs = &chunkStream{}
+			r.streams[csid] = cs
+		}
+
+		var ts uint32
+
+		switch format {
+		case 0:
+			if b, err = r.readFull(11); err != nil {
+				return nil, err
+			}
+			ts = uint24(b)
+			cs.length = uint24(b[3:])
+			cs.typ = b[6]
+			cs.streamID = binary.LittleEndian.Uint32(b[7:])
+		case 1:
+			if b, err = r.readFull(7); err != nil {
+				return nil, err
+			}
+			ts = uint24(b)
+			cs.length = uint24(b[3:])
+			cs.typ = b[6]
+		case 2:
+			if b, err = r.readFull(3); err != nil {
+				return nil, err
+			}
+			ts = uint24(b)
+		}
+
+		if format < 2 && cs.payload != nil {
+			// new message header in the middle of message, partial one is dropped
+			cs.payload = nil
+		}
+
+		if format < 3 {
+			cs.extended = ts == extendedTS
+		}
+		if cs.extended {
+			if b, err = r.readFull(4); err != nil {
+				return nil, err
+			}
+			if format < 3 {
+				ts = binary.BigEndian.Uint32(b)
+			}
+		}
+
+		// timestamp is applied on first chunk of message
+		if cs.payload == nil {
+			switch format {
+			case 0:
+				cs.timestamp = ts
+				cs.delta = 0
+			case 1, 2:
+				cs.delta = ts
+				cs.timestamp += ts
+			case 3:
+				cs.timestamp += cs.delta
+			}
+			cs.payload = make([]byte, 0, cs.length)
+		}
+
+		i := len(cs.payload)
+		if uint32(i) > cs.length {
+			return nil, errors.New("rtmp: wrong chunk length")
+		}
+		n := min(cs.length-uint32(i), r.size)
+		cs.payload = cs.payload[:i+int(n)]
+		if _, err = io.ReadFull(r.r, cs.payload[i:]); err != nil {
+			return nil, err
+		}
+		r.read += uint64(n)
+
+		if uint32(len(cs.payload)) == cs.length {
+			msg := &Message{
+				Type:      cs.typ,
+				StreamID:  cs.streamID,
+				Timestamp: cs.timestamp,
+				Payload:   cs.payload,
+			}
+			cs.payload = nil
+			return msg, nil
+		}
+	}
+}
+
+// SetChunkSize applies Set Chunk Size message from remote side
+func (r *chunkReader) SetChunkSize(size uint32) error {
+	size &= 0x7FFFFFFF
+	if size == 0 || size > maxChunkSize {
+		return ErrChunkSize
+	}
+	r.size = size
+	return nil
+}
+
+// Abort discards partially received message of chunk stream
+func (r *chunkReader) Abort(csid uint32) {
+	if cs := r.streams[csid]; cs != nil {
+		cs.payload = nil
+	}
+}
+
+// chunkWriter splits messages to chunks, not safe for concurrent use
+type chunkWriter struct {
+	w    io.Writer
+	size uint32
+}
+
+func (w *chunkWriter) WriteMessage(csid byte, msg *Message) error {
+	extended := msg.Timestamp >= extendedTS
+
+	n := len(msg.Payload)
+	b := make([]byte, 0, 16+n+n/int(w.size)*5)
+
+	// type 0 header, csid should be from 2 to 63
+	b = append(b, csid&0x3F)
+	if extended {
+		b = appendUint24(b, extendedTS)
+	} else {
+		b = appendUint24(b, msg.Timestamp)
+	}
+	b = appendUint24(b, uint32(n))
+	b = append(b, msg.Type)
+	b = binary.LittleEndian.AppendUint32(b, msg.StreamID)
+	if extended {
+		b = binary.BigEndian.AppendUint32(b, msg.Timestamp)
+	}
+
+	for payload := msg.Payload; ; {
+		size := min(len(payload), int(w.size))
+		b = append(b, payload[:size]...)
+		if payload = payload[size:]; len(payload) == 0 {
+			break
+		}
+
+		// type 3 header for next chunk
+		b = append(b, 0xC0|csid&0x3F)
+		if extended {
+			b = binary.BigEndian.AppendUint32(b, msg.Timestamp)
+		}
+	}
+
+	_, err := w.w.Write(b)
+	return err
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func appendUint24(b []byte, v uint32) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
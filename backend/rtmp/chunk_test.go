@@ -0,0 +1,82 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunks(t *testing.T) {
+	var buf bytes.Buffer
+	w := &chunkWriter{w: &buf, size: DefaultChunkSize}
+
+	big := bytes.Repeat([]byte{1, 2, 3}, 1000)
+
+	msgs := []*Message{
+		{Type: TypeVideo, StreamID: 1, Timestamp: 40, Payload: big},
+		{Type: TypeAudio, StreamID: 1, Timestamp: 0x1000000, Payload: big[:300]},
+		{Type: TypeCommandAMF0, Payload: EncodeAMF("_result", 1)},
+		{Type: TypeVideo, StreamID: 1, Timestamp: 80, Payload: []byte{}},
+	}
+
+	for _, msg := range msgs {
+		csid := byte(csidStream)
+		if msg.StreamID == 0 {
+			csid = csidCommand
+		}
+		require.NoError(t, w.WriteMessage(csid, msg))
+	}
+
+	r := newChunkReader(&buf)
+	for _, want := range msgs {
+		msg, err := r.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, want, msg)
+	}
+
+	// the same with bigger chunks
+	w.size = 4096
+	require.NoError(t, w.WriteMessage(csidStream, msgs[0]))
+	require.NoError(t, r.SetChunkSize(4096))
+
+	msg, err := r.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, msgs[0], msg)
+	require.Equal(t, uint64(0), uint64(buf.Len()))
+}
+
+func TestChunkDelta(t *testing.T) {
+	// type 0 with timestamp 100, type 2 with delta 20, type 3 reuses delta
+	b := []byte{0x04, 0, 0, 100, 0, 0, 1, TypeAudio, 1, 0, 0, 0, 0xAA}
+	b = append(b, 0x84, 0, 0, 20, 0xBB)
+	b = append(b, 0xC4, 0xCC)
+
+	r := newChunkReader(bytes.NewReader(b))
+	for _, want := range []uint32{100, 120, 140} {
+		msg, err := r.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, want, msg.Timestamp)
+		require.Equal(t, uint32(1), msg.StreamID)
+		require.Len(t, msg.Payload, 1)
+	}
+}
+
+func TestChunkHeaderMidMessage(t *testing.T) {
+	// type 0 starts 200 bytes message, type 1 in the middle changes length to 300
+	b := []byte{0x04, 0, 0, 0, 0, 0, 200, TypeVideo, 1, 0, 0, 0}
+	b = append(b, make([]byte, DefaultChunkSize)...)
+	b = append(b, 0x44, 0, 0, 40, 0, 1, 0x2C, TypeVideo)
+	b = append(b, bytes.Repeat([]byte{1}, DefaultChunkSize)...)
+	b = append(b, 0xC4)
+	b = append(b, bytes.Repeat([]byte{1}, DefaultChunkSize)...)
+	b = append(b, 0xC4)
+	b = append(b, bytes.Repeat([]byte{1}, 300-2*DefaultChunkSize)...)
+
+	// partial message is dropped, new one is read from the start
+	r := newChunkReader(bytes.NewReader(b))
+	msg, err := r.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, uint32(40), msg.Timestamp)
+	require.Equal(t, bytes.Repeat([]byte{1}, 300), msg.Payload)
+}
@@ -0,0 +1,675 @@
+package rtmp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+const (
+	handshakeSize = 1536
+	chunkSize     = 4096
+
+	// maxProbeFrames - how many frames Play reads while waiting for sequence
+	// headers of all tracks
+	maxProbeFrames = 100
+)
+
+var ErrNoTracks = errors.New("rtmp: no supported tracks")
+
+// Client plays stream from RTMP server (nginx-rtmp, SRS, MediaMTX, encoders).
+// H264 and AAC tracks are delivered as RTP packets by rtsp.Receiver,
+// the same way as medias of rtsp.Client.
+type Client struct {
+	URL *url.URL
+
+	// TLS - settings for rtmps:// connections, optional
+	TLS *rtsp.TLSOptions
+
+	// Dialer - opens TCP connection to server, optional
+	Dialer rtsp.Dialer
+
+	// Proxy - socks5://, socks5h:// or http:// proxy URL, see rtsp.NewProxyDialer.
+	// RTSP_PROXY or ALL_PROXY environment variable if empty, rtsp.ProxyDirect to ignore them.
+	Proxy string
+
+	// Timeout - read timeout in seconds, 5 seconds if zero
+	Timeout int
+
+	Medias    []*rtsp.Media
+	Receivers []*rtsp.Receiver
+
+	// Metadata from onMetaData message, if server sent it before media
+	Metadata map[string]any
+
+	uri      string
+	timeout  time.Duration
+	conn     net.Conn
+	reader   *chunkReader
+	writer   *chunkWriter
+	writeMu  sync.Mutex
+	streamID uint32
+	window   uint32
+	acked    uint64
+
+	video, audio *track
+	pending      []*Message
+}
+
+func NewClient(uri string) *Client {
+	return &Client{uri: uri, timeout: time.Second * 30}
+}
+
+func (c *Client) Dial() (err error) {
+	return c.DialContext(context.Background())
+}
+
+// DialContext connects to server, ctx aborts TCP connect
+func (c *Client) DialContext(ctx context.Context) (err error) {
+	if c.URL, err = url.Parse(c.uri); err != nil {
+		return err
+	}
+
+	address := c.URL.Host
+	var secure *tls.Config
+
+	switch c.URL.Scheme {
+	case "rtmp":
+		if c.URL.Port() == "" {
+			address += ":1935"
+		}
+	case "rtmps", "rtmpx":
+		if c.URL.Port() == "" {
+			address += ":443"
+		}
+		secure = rtsp.TLSConfig(c.TLS, c.URL.Scheme, c.URL.Hostname())
+	default:
+		return errors.New("unsupported scheme: " + c.URL.Scheme)
+	}
+
+	dialer := c.Dialer
+	if dialer == nil {
+		if dialer, err = rtsp.NewDialer(c.Proxy, c.URL.Hostname(), c.timeout); err != nil {
+			return err
+		}
+	}
+
+	// timeout also limits proxy handshake
+	dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(dialCtx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	if secure != nil {
+		conn = tls.Client(conn, secure)
+	}
+
+	if err = c.handshake(conn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	c.conn = conn
+	c.reader = newChunkReader(conn)
+	c.writer = &chunkWriter{w: conn, size: DefaultChunkSize}
+	c.window = 0
+	c.acked = 0
+	c.video, c.audio = nil, nil
+	c.pending = nil
+	return nil
+}
+
+// handshake - simple handshake without digest, supported by all servers
+func (c *Client) handshake(conn net.Conn) error {
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+
+	// C0 version + C1 time, zero and random bytes
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = 3
+	for i := 9; i < len(c0c1); i++ {
+		c0c1[i] = byte(rand.Uint32())
+	}
+	if _, err := conn.Write(c0c1); err != nil {
+		return err
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	if _, err := io.ReadFull(conn, s0s1s2); err != nil {
+		return err
+	}
+	if s0s1s2[0] != 3 {
+		return fmt.Errorf("rtmp: unsupported version %d", s0s1s2[0])
+	}
+
+	// C2 is echo of S1
+	if _, err := conn.Write(s0s1s2[1 : 1+handshakeSize]); err != nil {
+		return err
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// Play connects to application, starts stream and waits for sequence headers.
+// After it Medias and Receivers are ready for handlers.
+func (c *Client) Play() error {
+	app, stream := parsePath(c.URL)
+	if stream == "" {
+		return errors.New("rtmp: stream name is empty")
+	}
+
+	if err := c.setChunkSize(chunkSize); err != nil {
+		return err
+	}
+
+	scheme := c.URL.Scheme
+	if scheme == "rtmpx" {
+		scheme = "rtmps"
+	}
+	tcURL := scheme + "://" + c.URL.Host + "/" + app
+
+	err := c.command(0, "connect", 1, map[string]any{
+		"app":           app,
+		"flashVer":      "LNX 9,0,124,2",
+		"tcUrl":         tcURL,
+		"fpad":          false,
+		"capabilities":  15,
+		"audioCodecs":   0x0FFF,
+		"videoCodecs":   0x00FF,
+		"videoFunction": 1,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = c.waitResult(1); err != nil {
+		return err
+	}
+
+	if err = c.command(0, "createStream", 2, nil); err != nil {
+		return err
+	}
+	values, err := c.waitResult(2)
+	if err != nil {
+		return err
+	}
+	if len(values) < 4 {
+		return errors.New("rtmp: wrong createStream response")
+	}
+	id, ok := values[3].(float64)
+	if !ok {
+		return errors.New("rtmp: wrong createStream response")
+	}
+	c.streamID = uint32(id)
+
+	// start -2 means live stream or recorded if there is no live
+	if err = c.command(c.streamID, "play", 0, nil, stream, -2); err != nil {
+		return err
+	}
+
+	// some servers wait buffer length before sending data
+	payload := binary.BigEndian.AppendUint16(nil, EventSetBufferLen)
+	payload = binary.BigEndian.AppendUint32(payload, c.streamID)
+	payload = binary.BigEndian.AppendUint32(payload, 3000)
+	if err = c.writeControl(TypeUserControl, payload); err != nil {
+		return err
+	}
+
+	return c.probe()
+}
+
+// Handle delivers packets to receivers until error or end of stream
+func (c *Client) Handle() error {
+	pending := c.pending
+	c.pending = nil
+	for _, msg := range pending {
+		if err := c.handleMedia(msg); err != nil {
+			return err
+		}
+	}
+
+	timeout := time.Second * 5
+	if c.Timeout != 0 {
+		timeout = time.Second * time.Duration(c.Timeout)
+	}
+
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+
+		msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case TypeAudio, TypeVideo:
+			err = c.handleMedia(msg)
+		case TypeAggregate:
+			var msgs []*Message
+			if msgs, err = splitAggregate(msg); err != nil {
+				return err
+			}
+			for _, msg = range msgs {
+				if err = c.handleMedia(msg); err != nil {
+					return err
+				}
+			}
+		case TypeCommandAMF0:
+			err = c.handleStatus(msg)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// probe reads messages until sequence headers of all tracks and creates receivers.
+// Frames are kept for Handle, so the first keyframe is not lost.
+func (c *Client) probe() error {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+
+	for frames := 0; !c.tracksReady(frames); {
+		msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+
+		msgs := []*Message{msg}
+
+		switch msg.Type {
+		case TypeCommandAMF0:
+			if err = c.handleStatus(msg); err != nil {
+				return err
+			}
+			continue
+		case TypeDataAMF0:
+			c.handleMetadata(msg)
+			continue
+		case TypeAggregate:
+			if msgs, err = splitAggregate(msg); err != nil {
+				return err
+			}
+		case TypeAudio, TypeVideo:
+		default:
+			continue
+		}
+
+		for _, msg = range msgs {
+			if isSequenceHeader(msg) {
+				if err = c.handleMedia(msg); err != nil {
+					return err
+				}
+			} else {
+				c.pending = append(c.pending, msg)
+				frames++
+			}
+		}
+	}
+
+	var tracks []*track
+	for _, t := range []*track{c.video, c.audio} {
+		if t != nil {
+			tracks = append(tracks, t)
+		}
+	}
+
+	if len(tracks) == 0 {
+		return ErrNoTracks
+	}
+
+	for i, t := range tracks {
+		kind := rtsp.KindVideo
+		if t == c.audio {
+			kind = rtsp.KindAudio
+		}
+
+		// receivers survive reconnect, so handlers stay subscribed
+		if i < len(c.Receivers) && c.Receivers[i].Media.Kind == kind {
+			t.receiver = c.Receivers[i]
+			continue
+		}
+
+		media := &rtsp.Media{
+			Kind:      kind,
+			Direction: rtsp.DirectionRecvonly,
+			Codecs:    []*rtsp.Codec{t.codec},
+			ID:        "trackID=" + strconv.Itoa(i),
+		}
+
+		t.receiver = rtsp.NewReceiver(media, byte(i*2))
+		c.Medias = append(c.Medias[:i], media)
+		c.Receivers = append(c.Receivers[:i], t.receiver)
+	}
+
+	return nil
+}
+
+// tracksReady returns true when all tracks from metadata are known,
+// or when there are no sequence headers for too long
+func (c *Client) tracksReady(frames int) bool {
+	if frames >= maxProbeFrames {
+		return true
+	}
+
+	wantVideo, wantAudio := true, true
+	if c.Metadata != nil {
+		wantVideo = isCodec(c.Metadata["videocodecid"], codecAVC, "avc1")
+		wantAudio = isCodec(c.Metadata["audiocodecid"], codecAAC, "mp4a")
+		if !wantVideo && !wantAudio {
+			return false
+		}
+	}
+
+	return (!wantVideo || c.video != nil) && (!wantAudio || c.audio != nil)
+}
+
+func isCodec(v any, id float64, fourCC string) bool {
+	switch v := v.(type) {
+	case float64:
+		return v == id
+	case string:
+		return v == fourCC
+	}
+	return false
+}
+
+func isSequenceHeader(msg *Message) bool {
+	return len(msg.Payload) >= 2 && msg.Payload[1] == packetSequenceHeader &&
+		(msg.Type == TypeVideo && msg.Payload[0]&0xF == codecAVC ||
+			msg.Type == TypeAudio && msg.Payload[0]>>4 == codecAAC)
+}
+
+// handleMedia creates or updates track on sequence header and writes frames
+func (c *Client) handleMedia(msg *Message) error {
+	b := msg.Payload
+
+	switch msg.Type {
+	case TypeVideo:
+		// frame type and codec, packet type, composition time
+		if len(b) < 5 || b[0]&0xF != codecAVC {
+			return nil // unsupported codec
+		}
+
+		switch b[1] {
+		case packetSequenceHeader:
+			t, err := newVideoTrack(b[5:])
+			if err != nil {
+				return err
+			}
+			if c.video == nil {
+				c.video = t
+			} else {
+				// new parameter sets, for example resolution change
+				c.video.sps, c.video.pps, c.video.lengthSize = t.sps, t.pps, t.lengthSize
+			}
+		case packetData:
+			if c.video == nil || c.video.receiver == nil {
+				return nil
+			}
+			cts := int32(uint24(b[2:])<<8) >> 8
+			return c.video.writeVideo(msg.Timestamp, b[0]>>4 == frameKey, cts, b[5:])
+		}
+
+	case TypeAudio:
+		// sound format, packet type
+		if len(b) < 2 || b[0]>>4 != codecAAC {
+			return nil
+		}
+
+		switch b[1] {
+		case packetSequenceHeader:
+			if c.audio == nil {
+				t, err := newAudioTrack(b[2:])
+				if err != nil {
+					return err
+				}
+				c.audio = t
+			}
+		case packetData:
+			if c.audio == nil || c.audio.receiver == nil {
+				return nil
+			}
+			c.audio.write(msg.Timestamp, b[2:])
+		}
+	}
+
+	return nil
+}
+
+// handleStatus returns error for onStatus with error level or end of stream
+func (c *Client) handleStatus(msg *Message) error {
+	values, err := DecodeAMF(msg.Payload)
+	if err != nil || len(values) < 4 || values[0] != "onStatus" {
+		return nil
+	}
+
+	info, _ := values[3].(map[string]any)
+	code, _ := info["code"].(string)
+
+	switch code {
+	case "NetStream.Play.Stop", "NetStream.Play.UnpublishNotify":
+		return io.EOF
+	}
+
+	if level, _ := info["level"].(string); level == "error" {
+		description, _ := info["description"].(string)
+		return fmt.Errorf("rtmp: %s %s", code, description)
+	}
+
+	return nil
+}
+
+func (c *Client) handleMetadata(msg *Message) {
+	values, err := DecodeAMF(msg.Payload)
+	if err != nil {
+		return
+	}
+
+	// data from publisher may be wrapped
+	if len(values) > 0 && values[0] == "@setDataFrame" {
+		values = values[1:]
+	}
+
+	if len(values) > 1 && values[0] == "onMetaData" {
+		if metadata, ok := values[1].(map[string]any); ok {
+			c.Metadata = metadata
+		}
+	}
+}
+
+// waitResult reads messages until _result of transaction
+func (c *Client) waitResult(transaction float64) ([]any, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Type != TypeCommandAMF0 {
+			continue
+		}
+
+		values, err := DecodeAMF(msg.Payload)
+		if err != nil || len(values) < 2 || values[1] != transaction {
+			continue
+		}
+
+		switch values[0] {
+		case "_result":
+			return values, nil
+		case "_error":
+			var description string
+			if len(values) > 3 {
+				info, _ := values[3].(map[string]any)
+				description, _ = info["description"].(string)
+			}
+			return nil, errors.New("rtmp: command error " + description)
+		}
+	}
+}
+
+// readMessage handles protocol control messages and returns others
+func (c *Client) readMessage() (*Message, error) {
+	for {
+		msg, err := c.reader.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.window > 0 && c.reader.read-c.acked >= uint64(c.window) {
+			c.acked = c.reader.read
+			payload := binary.BigEndian.AppendUint32(nil, uint32(c.acked))
+			if err = c.writeControl(TypeAck, payload); err != nil {
+				return nil, err
+			}
+		}
+
+		switch msg.Type {
+		case TypeSetChunkSize:
+			if len(msg.Payload) < 4 {
+				return nil, ErrChunkSize
+			}
+			if err = c.reader.SetChunkSize(binary.BigEndian.Uint32(msg.Payload)); err != nil {
+				return nil, err
+			}
+		case TypeAbort:
+			if len(msg.Payload) >= 4 {
+				c.reader.Abort(binary.BigEndian.Uint32(msg.Payload))
+			}
+		case TypeAck:
+		case TypeWindowAckSize:
+			if len(msg.Payload) >= 4 {
+				c.window = binary.BigEndian.Uint32(msg.Payload)
+			}
+		case TypeSetPeerBandwidth:
+			if len(msg.Payload) >= 4 {
+				if err = c.writeControl(TypeWindowAckSize, msg.Payload[:4]); err != nil {
+					return nil, err
+				}
+			}
+		case TypeUserControl:
+			if len(msg.Payload) < 2 {
+				continue
+			}
+			switch binary.BigEndian.Uint16(msg.Payload) {
+			case EventStreamEOF:
+				return nil, io.EOF
+			case EventPingRequest:
+				payload := binary.BigEndian.AppendUint16(nil, EventPingResponse)
+				payload = append(payload, msg.Payload[2:]...)
+				if err = c.writeControl(TypeUserControl, payload); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return msg, nil
+		}
+	}
+}
+
+func (c *Client) command(streamID uint32, values ...any) error {
+	csid := byte(csidCommand)
+	if streamID != 0 {
+		csid = csidStream
+	}
+
+	return c.writeMessage(csid, &Message{
+		Type: TypeCommandAMF0, StreamID: streamID, Payload: EncodeAMF(values...),
+	})
+}
+
+func (c *Client) writeControl(typ byte, payload []byte) error {
+	return c.writeMessage(csidControl, &Message{Type: typ, Payload: payload})
+}
+
+func (c *Client) setChunkSize(size uint32) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	msg := &Message{Type: TypeSetChunkSize, Payload: binary.BigEndian.AppendUint32(nil, size)}
+	if err := c.writer.WriteMessage(csidControl, msg); err != nil {
+		return err
+	}
+
+	c.writer.size = size
+	return nil
+}
+
+func (c *Client) writeMessage(csid byte, msg *Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	return c.writer.WriteMessage(csid, msg)
+}
+
+// parsePath splits `/app/instance/stream?token=1` to app and stream name
+func parsePath(u *url.URL) (app, stream string) {
+	path := strings.Trim(u.Path, "/")
+
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		return path, ""
+	}
+
+	app, stream = path[:i], path[i+1:]
+	if u.RawQuery != "" {
+		stream += "?" + u.RawQuery
+	}
+	return
+}
+
+// splitAggregate returns audio and video messages of aggregate message
+func splitAggregate(msg *Message) ([]*Message, error) {
+	tags, err := parseAggregate(msg.Payload)
+	if err != nil || len(tags) == 0 {
+		return nil, err
+	}
+
+	// tag timestamps are relative to the first one
+	base := tags[0].Timestamp
+
+	msgs := make([]*Message, 0, len(tags))
+	for _, tag := range tags {
+		msgs = append(msgs, &Message{
+			Type:      tag.Type,
+			StreamID:  msg.StreamID,
+			Timestamp: msg.Timestamp + tag.Timestamp - base,
+			Payload:   tag.Data,
+		})
+	}
+	return msgs, nil
+}
@@ -0,0 +1,228 @@
+package rtmp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0xC0, 0x1E, 0xD9, 0x00, 0xA0, 0x47, 0xFE, 0xC8}
+	testPPS = []byte{0x68, 0xCE, 0x3C, 0x80}
+	testASC = []byte{0x12, 0x10} // AAC LC, 44100 Hz, stereo
+)
+
+// serveTestStream plays H264 and AAC stream to one client and sends EOF
+func serveTestStream(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(conn, c0c1); err != nil {
+		return
+	}
+	s0s1s2 := append([]byte{3}, make([]byte, handshakeSize)...)
+	s0s1s2 = append(s0s1s2, c0c1[1:]...)
+	if _, err := conn.Write(s0s1s2); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, handshakeSize)); err != nil {
+		return
+	}
+
+	r := newChunkReader(conn)
+	w := &chunkWriter{w: conn, size: DefaultChunkSize}
+
+	write := func(csid byte, msg *Message) {
+		require.NoError(t, w.WriteMessage(csid, msg))
+	}
+
+	// small window to test acknowledgements
+	write(csidControl, &Message{Type: TypeWindowAckSize, Payload: binary.BigEndian.AppendUint32(nil, 100)})
+
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case TypeSetChunkSize:
+			require.NoError(t, r.SetChunkSize(binary.BigEndian.Uint32(msg.Payload)))
+			continue
+		case TypeCommandAMF0:
+		default:
+			continue
+		}
+
+		values, err := DecodeAMF(msg.Payload)
+		require.NoError(t, err)
+
+		switch values[0] {
+		case "connect":
+			require.Equal(t, "live", values[2].(map[string]any)["app"])
+			require.Equal(t, "rtmp://"+conn.LocalAddr().String()+"/live", values[2].(map[string]any)["tcUrl"])
+			write(csidCommand, &Message{Type: TypeCommandAMF0, Payload: EncodeAMF(
+				"_result", 1, map[string]any{"fmsVer": "FMS/3,0,1,123"},
+				map[string]any{"level": "status", "code": "NetConnection.Connect.Success"},
+			)})
+		case "createStream":
+			write(csidCommand, &Message{Type: TypeCommandAMF0, Payload: EncodeAMF("_result", 2, nil, 1)})
+		case "play":
+			require.Equal(t, "camera1?token=1", values[3])
+
+			write(csidStream, &Message{Type: TypeCommandAMF0, StreamID: 1, Payload: EncodeAMF(
+				"onStatus", 0, nil, map[string]any{"level": "status", "code": "NetStream.Play.Start"},
+			)})
+			write(csidStream, &Message{Type: TypeDataAMF0, StreamID: 1, Payload: EncodeAMF(
+				"onMetaData", map[string]any{"videocodecid": 7, "audiocodecid": 10},
+			)})
+
+			config := []byte{0x17, 0, 0, 0, 0, 1, 0x42, 0xC0, 0x1E, 0xFF, 0xE1}
+			config = binary.BigEndian.AppendUint16(config, uint16(len(testSPS)))
+			config = append(config, testSPS...)
+			config = append(config, 1)
+			config = binary.BigEndian.AppendUint16(config, uint16(len(testPPS)))
+			config = append(config, testPPS...)
+			write(csidStream, &Message{Type: TypeVideo, StreamID: 1, Payload: config})
+
+			write(csidStream, &Message{Type: TypeAudio, StreamID: 1, Payload: append([]byte{0xAF, 0}, testASC...)})
+
+			// keyframe with 3000 bytes IDR
+			idr := append([]byte{0x65}, make([]byte, 2999)...)
+			frame := []byte{0x17, 1, 0, 0, 0}
+			frame = binary.BigEndian.AppendUint32(frame, uint32(len(idr)))
+			frame = append(frame, idr...)
+			write(csidStream, &Message{Type: TypeVideo, StreamID: 1, Timestamp: 1000, Payload: frame})
+
+			// audio frame inside aggregate message
+			audio := []byte{0xAF, 1, 0x21, 0x00, 0x03}
+			tag := []byte{TypeAudio, 0, 0, byte(len(audio)), 0, 0, 0, 0, 0, 0, 0}
+			tag = append(tag, audio...)
+			tag = binary.BigEndian.AppendUint32(tag, uint32(11+len(audio)))
+			write(csidStream, &Message{Type: TypeAggregate, StreamID: 1, Timestamp: 1000, Payload: tag})
+
+			write(csidControl, &Message{Type: TypeUserControl, Payload: []byte{0, EventStreamEOF, 0, 0, 0, 1}})
+		}
+	}
+}
+
+func TestClientPlay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			serveTestStream(t, conn)
+		}
+	}()
+
+	client := NewClient("rtmp://" + ln.Addr().String() + "/live/camera1?token=1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Play())
+	require.Len(t, client.Receivers, 2)
+
+	video := client.Medias[0]
+	require.Equal(t, rtsp.KindVideo, video.Kind)
+	require.Equal(t, rtsp.CodecH264, video.Codecs[0].Name)
+	require.Equal(t,
+		"packetization-mode=1;profile-level-id=42c01e;sprop-parameter-sets=Z0LAHtkAoEf+yA==,aM48gA==",
+		video.Codecs[0].FmtpLine,
+	)
+
+	audio := client.Medias[1]
+	require.Equal(t, rtsp.KindAudio, audio.Kind)
+	require.Equal(t, rtsp.CodecAAC, audio.Codecs[0].Name)
+	require.Equal(t, uint32(44100), audio.Codecs[0].ClockRate)
+	require.Equal(t, uint16(2), audio.Codecs[0].Channels)
+
+	var videoPackets, audioPackets []*rtp.Packet
+
+	videoHandler := &rtsp.Handler{
+		OnRTP:  func(packet *rtp.Packet) { videoPackets = append(videoPackets, packet) },
+		Policy: rtsp.Block,
+	}
+	audioHandler := &rtsp.Handler{
+		OnRTP:  func(packet *rtp.Packet) { audioPackets = append(audioPackets, packet) },
+		Policy: rtsp.Block,
+	}
+	client.Receivers[0].AddHandler(videoHandler)
+	client.Receivers[1].AddHandler(audioHandler)
+
+	require.ErrorIs(t, client.Handle(), io.EOF)
+
+	client.Receivers[0].RemoveHandler(videoHandler)
+	client.Receivers[1].RemoveHandler(audioHandler)
+	<-videoHandler.Done()
+	<-audioHandler.Done()
+
+	// SPS and PPS in STAP-A, IDR in FU-A fragments
+	require.Greater(t, len(videoPackets), 2)
+	require.Equal(t, byte(24), videoPackets[0].Payload[0]&0x1F)
+	require.Equal(t, byte(28), videoPackets[1].Payload[0]&0x1F)
+	require.True(t, videoPackets[len(videoPackets)-1].Marker)
+	require.Equal(t, uint32(1000*90), videoPackets[0].Timestamp)
+
+	require.Len(t, audioPackets, 1)
+	require.Equal(t, []byte{0, 16, 0, 3 << 3, 0x21, 0x00, 0x03}, audioPackets[0].Payload)
+	require.Equal(t, uint32(44100), audioPackets[0].Timestamp)
+}
+
+type testDialer struct {
+	address string
+}
+
+func (d *testDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	// camera name is resolved by custom dialer
+	if address != "camera1:1935" {
+		return nil, errors.New("wrong address: " + address)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, d.address)
+}
+
+func TestClientDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			serveTestStream(t, conn)
+		}
+	}()
+
+	client := NewClient("rtmp://camera1/live/camera1?token=1")
+	client.Dialer = &testDialer{address: ln.Addr().String()}
+	require.NoError(t, client.Dial())
+	defer client.Close()
+}
+
+func TestParsePath(t *testing.T) {
+	for _, test := range []struct {
+		URL, App, Stream string
+	}{
+		{URL: "rtmp://host/live/stream", App: "live", Stream: "stream"},
+		{URL: "rtmp://host/app/instance/stream?key=1", App: "app/instance", Stream: "stream?key=1"},
+		{URL: "rtmp://host/live", App: "live"},
+	} {
+		u, err := url.Parse(test.URL)
+		require.NoError(t, err)
+
+		app, stream := parsePath(u)
+		require.Equal(t, test.App, app)
+		require.Equal(t, test.Stream, stream)
+	}
+}
@@ -0,0 +1,253 @@
+package rtmp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+	"github.com/vtpl1/phoring/backend/rtp/codecs"
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+// FLV tag codecs https://veovera.org/docs/legacy/video-file-format-v10-1-spec.pdf
+const (
+	codecAVC = 7  // video CodecID
+	codecAAC = 10 // audio SoundFormat
+
+	packetSequenceHeader = 0 // AVCPacketType and AACPacketType
+	packetData           = 1
+
+	frameKey = 1 // video FrameType
+)
+
+const (
+	payloadTypeVideo = 96
+	payloadTypeAudio = 97
+)
+
+var errNotSequenceHeader = errors.New("rtmp: wrong sequence header")
+
+// track converts FLV video or audio tags of one stream to RTP packets
+type track struct {
+	receiver  *rtsp.Receiver
+	payloader rtp.Payloader
+	sequencer rtp.Sequencer
+	ssrc      uint32
+
+	codec       *rtsp.Codec
+	clockRate   uint32
+	payloadType uint8
+
+	// H264 parameter sets in Annex B format and size of NALU length
+	sps, pps   []byte
+	lengthSize int
+}
+
+// newVideoTrack parses AVCDecoderConfigurationRecord
+func newVideoTrack(config []byte) (*track, error) {
+	// version, profile, compatibility, level, length size, SPS count
+	if len(config) < 6 || config[0] != 1 {
+		return nil, errNotSequenceHeader
+	}
+
+	t := &track{
+		payloader:   &codecs.H264Payloader{},
+		clockRate:   90000,
+		payloadType: payloadTypeVideo,
+		lengthSize:  int(config[4]&3) + 1,
+	}
+
+	var sps, pps [][]byte
+
+	b := config[5:]
+	for _, sets := range []*[][]byte{&sps, &pps} {
+		if len(b) < 1 {
+			return nil, errNotSequenceHeader
+		}
+		n := int(b[0])
+		if sets == &sps {
+			n &= 0x1F
+		}
+		b = b[1:]
+
+		for i := 0; i < n; i++ {
+			if len(b) < 2 {
+				return nil, errNotSequenceHeader
+			}
+			size := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+size {
+				return nil, errNotSequenceHeader
+			}
+			*sets = append(*sets, b[2:2+size])
+			b = b[2+size:]
+		}
+	}
+
+	if len(sps) == 0 || len(pps) == 0 || len(sps[0]) < 4 {
+		return nil, errNotSequenceHeader
+	}
+
+	t.sps = annexB(sps[0])
+	t.pps = annexB(pps[0])
+
+	t.codec = &rtsp.Codec{
+		Name:        rtsp.CodecH264,
+		ClockRate:   90000,
+		PayloadType: payloadTypeVideo,
+		FmtpLine: "packetization-mode=1;profile-level-id=" + hex.EncodeToString(sps[0][1:4]) +
+			";sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(sps[0]) +
+			"," + base64.StdEncoding.EncodeToString(pps[0]),
+	}
+
+	return t, nil
+}
+
+// newAudioTrack parses AudioSpecificConfig
+func newAudioTrack(config []byte) (*track, error) {
+	sampleRate, channels := rtsp.ParseAudioSpecificConfig(config)
+	if sampleRate == 0 {
+		return nil, errNotSequenceHeader
+	}
+
+	t := &track{
+		payloader:   &aacPayloader{},
+		clockRate:   sampleRate,
+		payloadType: payloadTypeAudio,
+	}
+
+	t.codec = &rtsp.Codec{
+		Name:        rtsp.CodecAAC,
+		ClockRate:   sampleRate,
+		Channels:    channels,
+		PayloadType: payloadTypeAudio,
+		FmtpLine: "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=" +
+			hex.EncodeToString(config),
+	}
+
+	return t, nil
+}
+
+// writeVideo handles AVC NALUs tag without header bytes
+func (t *track) writeVideo(timestamp uint32, key bool, cts int32, data []byte) error {
+	var frame []byte
+	var hasSPS bool
+
+	for b := data; len(b) > 0; {
+		if len(b) < t.lengthSize {
+			return errors.New("rtmp: wrong NALU length")
+		}
+
+		var size int
+		for _, v := range b[:t.lengthSize] {
+			size = size<<8 | int(v)
+		}
+		b = b[t.lengthSize:]
+
+		if size > len(b) {
+			return errors.New("rtmp: wrong NALU length")
+		}
+		if size == 0 {
+			continue // some encoders pad frame with empty NALU
+		}
+
+		if b[0]&0x1F == 7 {
+			hasSPS = true
+		}
+
+		frame = append(frame, 0, 0, 0, 1)
+		frame = append(frame, b[:size]...)
+		b = b[size:]
+	}
+
+	// most encoders send parameter sets only in sequence header
+	if key && !hasSPS {
+		frame = append(append(append([]byte(nil), t.sps...), t.pps...), frame...)
+	}
+
+	t.write(uint32(int64(timestamp)+int64(cts)), frame)
+	return nil
+}
+
+// write packetizes frame with timestamp in milliseconds
+func (t *track) write(timestamp uint32, frame []byte) {
+	if t.sequencer == nil {
+		t.sequencer = rtp.NewRandomSequencer()
+		t.ssrc = rand.Uint32()
+	}
+
+	ts := uint32(uint64(timestamp) * uint64(t.clockRate) / 1000)
+
+	payloads := t.payloader.Payload(rtsp.PacketMTU-12, frame)
+	for i, payload := range payloads {
+		t.receiver.WriteRTP(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == len(payloads)-1,
+				PayloadType:    t.payloadType,
+				SequenceNumber: t.sequencer.NextSequenceNumber(),
+				Timestamp:      ts,
+				SSRC:           t.ssrc,
+			},
+			Payload: payload,
+		})
+	}
+}
+
+func annexB(nalu []byte) []byte {
+	return append([]byte{0, 0, 0, 1}, nalu...)
+}
+
+// aacPayloader packs one AAC frame per packet in RFC 3640 AAC-hbr mode
+type aacPayloader struct{}
+
+func (p *aacPayloader) Payload(mtu uint16, payload []byte) [][]byte {
+	if len(payload) == 0 || len(payload) > 0x1FFF {
+		return nil
+	}
+
+	// AU-headers-length in bits, AU-size 13 bits and AU-index 3 bits
+	b := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint16(b, 16)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(payload))<<3)
+	copy(b[4:], payload)
+	return [][]byte{b}
+}
+
+// flvTag - one tag of aggregate message
+type flvTag struct {
+	Type      byte
+	Timestamp uint32
+	Data      []byte
+}
+
+// parseAggregate splits aggregate message body to FLV tags
+func parseAggregate(b []byte) ([]flvTag, error) {
+	var tags []flvTag
+
+	for len(b) > 0 {
+		// type 1B, size 3B, timestamp 3B + 1B, stream id 3B
+		if len(b) < 11 {
+			return nil, fmt.Errorf("rtmp: wrong aggregate message")
+		}
+
+		size := uint24(b[1:])
+		if uint32(len(b)) < 11+size {
+			return nil, fmt.Errorf("rtmp: wrong aggregate message")
+		}
+
+		tags = append(tags, flvTag{
+			Type:      b[0],
+			Timestamp: uint32(b[7])<<24 | uint24(b[4:]),
+			Data:      b[11 : 11+size],
+		})
+
+		// skip previous tag size
+		b = b[min(uint32(len(b)), 11+size+4):]
+	}
+
+	return tags, nil
+}
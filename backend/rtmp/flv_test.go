@@ -0,0 +1,39 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp/codecs"
+	"github.com/vtpl1/phoring/backend/rtsp"
+)
+
+func TestTrackWriteVideo(t *testing.T) {
+	for _, test := range []struct {
+		Name  string
+		Data  []byte
+		Error bool
+	}{
+		{Name: "empty NALU at the end", Data: []byte{0, 0, 0, 2, 0x41, 1, 0, 0, 0, 0}},
+		{Name: "empty NALU in the middle", Data: []byte{0, 0, 0, 0, 0, 0, 0, 2, 0x41, 1}},
+		{Name: "only empty NALU", Data: []byte{0, 0, 0, 0}},
+		{Name: "short length", Data: []byte{0, 0, 0, 2, 0x41, 1, 0, 0}, Error: true},
+		{Name: "long length", Data: []byte{0, 0, 0, 3, 0x41, 1}, Error: true},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			track := &track{
+				payloader:  &codecs.H264Payloader{},
+				clockRate:  90000,
+				lengthSize: 4,
+				receiver:   rtsp.NewReceiver(&rtsp.Media{Kind: rtsp.KindVideo}, 0),
+			}
+
+			err := track.writeVideo(0, false, 0, test.Data)
+			if test.Error {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -2,19 +2,39 @@ package rtsp
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Auth struct {
-	Method  byte
-	user    string
-	pass    string
-	header  string
-	h1nonce string
+	Method byte
+	user   string
+	pass   string
+	header string
+
+	// digest challenge https://datatracker.ietf.org/doc/html/rfc7616
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string // empty for servers without algorithm param (MD5)
+	qop       string // auth, auth-int or empty
+	nc        uint32
+
+	// server side issued nonces with nonce count
+	nonces map[string]*serverNonce
+
+	mu sync.Mutex
 }
 
 const (
@@ -25,6 +45,14 @@ const (
 	AuthTPLink // https://drmnsamoliu.github.io/video.html
 )
 
+// NonceLifetime - how long server accepts issued digest nonce
+const NonceLifetime = time.Minute * 5
+
+type serverNonce struct {
+	created time.Time
+	nc      uint64
+}
+
 func NewAuth(user *url.Userinfo) *Auth {
 	a := &Auth{}
 	a.user = user.Username()
@@ -35,31 +63,58 @@ func NewAuth(user *url.Userinfo) *Auth {
 	return a
 }
 
+// Read selects the strongest challenge from all WWW-Authenticate headers
 func (a *Auth) Read(res *Response) bool {
-	auth := res.Header.Get("WWW-Authenticate")
-	if len(auth) < 6 {
+	var best string
+	var bestRank int
+
+	for _, auth := range res.Header.Values("WWW-Authenticate") {
+		if rank := challengeRank(auth); rank > bestRank {
+			best, bestRank = auth, rank
+		}
+	}
+
+	if bestRank == 0 {
 		return false
 	}
 
-	switch auth[:6] {
-	case "Basic ":
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if hasScheme(best, "Basic") {
 		a.header = "Basic " + B64(a.user, a.pass)
 		a.Method = AuthBasic
 		return true
-	case "Digest":
-		realm := Between(auth, `realm="`, `"`)
-		nonce := Between(auth, `nonce="`, `"`)
-
-		a.h1nonce = HexMD5(a.user, realm, a.pass) + ":" + nonce
-		a.header = fmt.Sprintf(
-			`Digest username="%s", realm="%s", nonce="%s"`,
-			a.user, realm, nonce,
-		)
-		a.Method = AuthDigest
-		return true
-	default:
-		return false
 	}
+
+	params := parseAuthParams(best[6:])
+
+	a.realm = params["realm"]
+	a.nonce = params["nonce"]
+	a.opaque = params["opaque"]
+	a.algorithm = params["algorithm"]
+	a.qop = selectQop(params["qop"])
+	a.nc = 0
+	a.Method = AuthDigest
+	return true
+}
+
+// IsStale returns true if server rejected only our nonce and sent new one,
+// so request can be repeated with the same credentials
+func (a *Auth) IsStale(res *Response) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, auth := range res.Header.Values("WWW-Authenticate") {
+		if !hasScheme(auth, "Digest") {
+			continue
+		}
+		params := parseAuthParams(auth[6:])
+		if strings.EqualFold(params["stale"], "true") && params["nonce"] != a.nonce {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *Auth) Write(req *Request) {
@@ -74,42 +129,248 @@ func (a *Auth) Write(req *Request) {
 		// important to use String except RequestURL for RtspServer:
 		// https://github.com/AlexxIT/go2rtc/issues/244
 		uri := req.URL.String()
-		h2 := HexMD5(req.Method, uri)
-		response := HexMD5(a.h1nonce, h2)
-		header := a.header + fmt.Sprintf(
-			`, uri="%s", response="%s"`, uri, response,
-		)
-		req.Header.Set("Authorization", header)
+		req.Header.Set("Authorization", a.digestHeader(req.Method, uri, req.Body))
 	case AuthTPLink:
 		req.URL.Host = "127.0.0.1"
 	}
 }
 
+func (a *Auth) digestHeader(method, uri string, body []byte) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var nc, cnonce string
+	if a.qop != "" {
+		a.nc++
+		nc = fmt.Sprintf("%08x", a.nc)
+		cnonce = randomHex(16)
+	}
+
+	response := digestResponse(
+		a.algorithm, a.user, a.realm, a.pass, a.nonce, nc, cnonce, a.qop, method, uri, body,
+	)
+
+	header := fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		a.user, a.realm, a.nonce, uri, response,
+	)
+	if a.algorithm != "" {
+		header += ", algorithm=" + a.algorithm
+	}
+	if a.opaque != "" {
+		header += `, opaque="` + a.opaque + `"`
+	}
+	if a.qop != "" {
+		header += ", qop=" + a.qop + ", nc=" + nc + `, cnonce="` + cnonce + `"`
+	}
+	return header
+}
+
+// Validate checks client credentials on server side, Basic and Digest
+// (with nonce from Challenge) are supported
 func (a *Auth) Validate(req *Request) bool {
 	if a == nil {
 		return true
 	}
 
 	header := req.Header.Get("Authorization")
-	if header == "" {
+
+	switch {
+	case hasScheme(header, "Basic "):
+		want := B64(a.user, a.pass)
+		return subtle.ConstantTimeCompare([]byte(header[6:]), []byte(want)) == 1
+	case hasScheme(header, "Digest "):
+		return a.validateDigest(req, parseAuthParams(header[7:]))
+	}
+
+	return false
+}
+
+func (a *Auth) validateDigest(req *Request, params map[string]string) bool {
+	if params["username"] != a.user {
 		return false
 	}
 
-	if a.Method == AuthUnknown {
-		a.Method = AuthBasic
-		a.header = "Basic " + B64(a.user, a.pass)
+	// Challenge offers only qop=auth, without it nonce count isn't checked
+	// and captured header can be replayed
+	if params["qop"] != "auth" {
+		return false
+	}
+
+	if uri := params["uri"]; uri != req.URL.String() && uri != req.URL.RequestURI() {
+		return false
+	}
+
+	// only algorithms from Challenge, MD5 if missing
+	switch strings.ToUpper(params["algorithm"]) {
+	case "", "MD5", "SHA-256":
+	default:
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if params["realm"] != a.realm {
+		return false
 	}
 
-	return header == a.header
+	nonce := a.nonces[params["nonce"]]
+	if nonce == nil || time.Since(nonce.created) > NonceLifetime {
+		return false
+	}
+
+	// nonce count must grow, otherwise it is replay
+	nc, err := strconv.ParseUint(params["nc"], 16, 32)
+	if err != nil || nc <= nonce.nc {
+		return false
+	}
+
+	want := digestResponse(
+		params["algorithm"], a.user, a.realm, a.pass, params["nonce"], params["nc"], params["cnonce"],
+		"auth", req.Method, params["uri"], req.Body,
+	)
+	if subtle.ConstantTimeCompare([]byte(params["response"]), []byte(want)) != 1 {
+		return false
+	}
+
+	nonce.nc = nc
+	return true
 }
 
-func (a *Auth) ReadNone(res *Response) bool {
-	auth := res.Header.Get("WWW-Authenticate")
-	if strings.Contains(auth, "TP-LINK Streaming Media") {
-		a.Method = AuthTPLink
-		return true
+// Challenge returns WWW-Authenticate values for server 401 response,
+// the strongest first
+func (a *Auth) Challenge(realm string) []string {
+	nonce := randomHex(16)
+
+	a.mu.Lock()
+	a.realm = realm
+	if a.nonces == nil {
+		a.nonces = map[string]*serverNonce{}
 	}
-	return false
+	for k, v := range a.nonces {
+		if time.Since(v.created) > NonceLifetime {
+			delete(a.nonces, k)
+		}
+	}
+	a.nonces[nonce] = &serverNonce{created: time.Now()}
+	a.mu.Unlock()
+
+	return []string{
+		fmt.Sprintf(`Digest realm="%s", nonce="%s", algorithm=SHA-256, qop="auth"`, realm, nonce),
+		fmt.Sprintf(`Digest realm="%s", nonce="%s", algorithm=MD5, qop="auth"`, realm, nonce),
+		fmt.Sprintf(`Basic realm="%s"`, realm),
+	}
+}
+
+// hasScheme checks auth scheme of header, scheme is case-insensitive
+func hasScheme(header, scheme string) bool {
+	return len(header) >= len(scheme) && strings.EqualFold(header[:len(scheme)], scheme)
+}
+
+// challengeRank returns 0 for unsupported challenge and bigger value for stronger
+func challengeRank(auth string) int {
+	switch {
+	case hasScheme(auth, "Basic"):
+		return 1
+	case hasScheme(auth, "Digest"):
+		params := parseAuthParams(auth[6:])
+		if params["qop"] != "" && selectQop(params["qop"]) == "" {
+			return 0
+		}
+		switch strings.TrimSuffix(strings.ToUpper(params["algorithm"]), "-SESS") {
+		case "", "MD5":
+			return 2
+		case "SHA-256":
+			return 3
+		case "SHA-512-256":
+			return 4
+		}
+	}
+	return 0
+}
+
+// selectQop prefers auth over auth-int, returns empty for unsupported options
+func selectQop(options string) string {
+	var authInt bool
+	for _, qop := range strings.Split(options, ",") {
+		switch strings.TrimSpace(qop) {
+		case "auth":
+			return "auth"
+		case "auth-int":
+			authInt = true
+		}
+	}
+	if authInt {
+		return "auth-int"
+	}
+	return ""
+}
+
+func digestResponse(algorithm, user, realm, pass, nonce, nc, cnonce, qop, method, uri string, body []byte) string {
+	h := digestHash(algorithm)
+
+	ha1 := HexHash(h, user, realm, pass)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = HexHash(h, ha1, nonce, cnonce)
+	}
+
+	ha2 := HexHash(h, method, uri)
+	if qop == "auth-int" {
+		ha2 = HexHash(h, method, uri, HexHash(h, string(body)))
+	}
+
+	if qop == "" {
+		return HexHash(h, ha1, nonce, ha2)
+	}
+	return HexHash(h, ha1, nonce, nc, cnonce, qop, ha2)
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	}
+	return md5.New
+}
+
+// parseAuthParams parses `realm="a, b", nonce="c", stale=TRUE` to map with lowercase keys
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " ")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			// quoted value may contain commas
+			if i = strings.IndexByte(s[1:], '"'); i < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:i+1], s[i+2:]
+			}
+		} else {
+			if i = strings.IndexByte(s, ','); i < 0 {
+				value, s = s, ""
+			} else {
+				value, s = s[:i], s[i+1:]
+			}
+			value = strings.TrimSpace(value)
+		}
+
+		params[key] = value
+	}
+
+	return params
 }
 
 func Between(s, sub1, sub2 string) string {
@@ -126,11 +387,25 @@ func Between(s, sub1, sub2 string) string {
 }
 
 func HexMD5(s ...string) string {
-	b := md5.Sum([]byte(strings.Join(s, ":")))
-	return hex.EncodeToString(b[:])
+	return HexHash(md5.New, s...)
+}
+
+func HexHash(h func() hash.Hash, s ...string) string {
+	hh := h()
+	hh.Write([]byte(strings.Join(s, ":")))
+	return hex.EncodeToString(hh.Sum(nil))
 }
 
 func B64(s ...string) string {
 	b := []byte(strings.Join(s, ":"))
 	return base64.StdEncoding.EncodeToString(b)
 }
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	// nonces must be unpredictable, system random source doesn't fail
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,176 @@
+package rtsp
+
+import (
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestResponse(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc7616#section-3.9.1
+	const (
+		user   = "Mufasa"
+		pass   = "Circle of Life"
+		realm  = "http-auth@example.org"
+		nonce  = "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+		cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	)
+
+	for _, test := range []struct {
+		Algorithm string
+		Want      string
+	}{
+		{Algorithm: "MD5", Want: "8ca523f5e9506fed4657c9700eebdbec"},
+		{Algorithm: "SHA-256", Want: "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	} {
+		t.Run(test.Algorithm, func(t *testing.T) {
+			response := digestResponse(
+				test.Algorithm, user, realm, pass, nonce, "00000001", cnonce, "auth", "GET", "/dir/index.html", nil,
+			)
+			require.Equal(t, test.Want, response)
+		})
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(` realm="a, b", nonce="123",stale=TRUE, algorithm=SHA-256, qop="auth,auth-int"`)
+	require.Equal(t, map[string]string{
+		"realm":     "a, b",
+		"nonce":     "123",
+		"stale":     "TRUE",
+		"algorithm": "SHA-256",
+		"qop":       "auth,auth-int",
+	}, params)
+}
+
+func TestAuthReadStrongest(t *testing.T) {
+	res := &Response{Header: textproto.MIMEHeader{}}
+	res.Header.Add("WWW-Authenticate", `Basic realm="cam"`)
+	res.Header.Add("WWW-Authenticate", `Digest realm="cam", nonce="1", algorithm=MD5`)
+	res.Header.Add("WWW-Authenticate", `Digest realm="cam", nonce="2", algorithm=SHA-256, qop="auth", opaque="x"`)
+	res.Header.Add("WWW-Authenticate", `Digest realm="cam", nonce="3", algorithm=SHA-256, qop="auth-conf"`)
+
+	a := NewAuth(url.UserPassword("admin", "secret"))
+	require.True(t, a.Read(res))
+	require.Equal(t, AuthDigest, a.Method)
+	require.Equal(t, "2", a.nonce)
+
+	u, _ := url.Parse("rtsp://cam/stream")
+	for _, nc := range []string{"00000001", "00000002"} {
+		req := &Request{Method: DESCRIBE, URL: u, Header: textproto.MIMEHeader{}}
+		a.Write(req)
+
+		header := req.Header.Get("Authorization")
+		require.True(t, strings.HasPrefix(header, "Digest "))
+
+		params := parseAuthParams(header[7:])
+		require.Equal(t, nc, params["nc"])
+		require.Equal(t, "SHA-256", params["algorithm"])
+		require.Equal(t, "auth", params["qop"])
+		require.Equal(t, "x", params["opaque"])
+		require.NotEmpty(t, params["cnonce"])
+	}
+
+	// new nonce with stale flag
+	res = &Response{Header: textproto.MIMEHeader{}}
+	res.Header.Add("WWW-Authenticate", `Digest realm="cam", nonce="4", algorithm=SHA-256, qop="auth", stale=true`)
+	require.True(t, a.IsStale(res))
+	require.True(t, a.Read(res))
+	require.False(t, a.IsStale(res))
+}
+
+func TestAuthValidateDigest(t *testing.T) {
+	server := NewAuth(url.UserPassword("admin", "secret"))
+
+	res := &Response{Header: textproto.MIMEHeader{}}
+	for _, challenge := range server.Challenge("cam") {
+		res.Header.Add("WWW-Authenticate", challenge)
+	}
+
+	u, _ := url.Parse("rtsp://cam/stream")
+	newRequest := func(a *Auth) *Request {
+		req := &Request{Method: DESCRIBE, URL: u, Header: textproto.MIMEHeader{}}
+		a.Write(req)
+		return req
+	}
+
+	client := NewAuth(url.UserPassword("admin", "secret"))
+	require.True(t, client.Read(res))
+	require.Equal(t, "SHA-256", client.algorithm)
+
+	req := newRequest(client)
+	require.True(t, server.Validate(req))
+	// replay with the same nonce count
+	require.False(t, server.Validate(req))
+	require.True(t, server.Validate(newRequest(client)))
+
+	wrong := NewAuth(url.UserPassword("admin", "wrong"))
+	require.True(t, wrong.Read(res))
+	require.False(t, server.Validate(newRequest(wrong)))
+
+	// unknown nonce
+	unknown := NewAuth(url.UserPassword("admin", "secret"))
+	require.True(t, unknown.Read(res))
+	unknown.nonce = "123"
+	require.False(t, server.Validate(newRequest(unknown)))
+
+	// without qop response could be replayed
+	noQop := NewAuth(url.UserPassword("admin", "secret"))
+	require.True(t, noQop.Read(res))
+	noQop.qop = ""
+	require.False(t, server.Validate(newRequest(noQop)))
+
+	// algorithm isn't from challenge
+	for _, test := range []struct {
+		algorithm string
+		ok        bool
+	}{
+		{"MD5-sess", false},
+		{"SHA-512-256", false},
+		{"MD5", true},
+	} {
+		client.algorithm = test.algorithm
+		require.Equal(t, test.ok, server.Validate(newRequest(client)), test.algorithm)
+	}
+	client.algorithm = "SHA-256"
+
+	// header of another URL
+	req = newRequest(client)
+	req.URL, _ = url.Parse("rtsp://cam/other")
+	require.False(t, server.Validate(req))
+
+	// scheme is case-insensitive
+	req = newRequest(client)
+	req.Header.Set("Authorization", "digest"+req.Header.Get("Authorization")[6:])
+	require.True(t, server.Validate(req))
+
+	basic := NewAuth(url.UserPassword("admin", "secret"))
+	req = &Request{Method: DESCRIBE, URL: u, Header: textproto.MIMEHeader{}}
+	req.Header.Set("Authorization", "BASIC "+B64("admin", "secret"))
+	require.True(t, basic.Validate(req))
+}
+
+func TestServerDigestAuth(t *testing.T) {
+	srv, address := startTestServer(t)
+	srv.Auth = NewAuth(url.UserPassword("admin", "secret"))
+	srv.AddStream("camera1", &Media{
+		Kind: KindVideo, Direction: DirectionRecvonly,
+		Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+	})
+
+	client := NewClient("rtsp://admin:secret@" + address + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Describe())
+	require.Equal(t, AuthDigest, client.auth.Method)
+
+	client = NewClient("rtsp://admin:wrong@" + address + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.Error(t, client.Describe())
+}
@@ -2,6 +2,7 @@ package rtsp
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
@@ -13,6 +14,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vtpl1/phoring/backend/rtcp"
@@ -26,20 +29,77 @@ type Client struct {
 	SDP         string
 	Media       string
 	Medias      []*Media
+	Receivers   []*Receiver
+	Senders     []*Sender
 	SessionName string
 	Timeout     int
+	Transport   string // tcp (default), udp or multicast
+
+	// MulticastInterface - network interface name for joining multicast group,
+	// system default if empty
+	MulticastInterface string
+
+	// KeepaliveMethod - OPTIONS, GET_PARAMETER or SET_PARAMETER,
+	// auto-detect from OPTIONS response if empty
+	KeepaliveMethod string
+
+	// TLS - settings for rtsps:// connections, optional
+	TLS *TLSOptions
+
+	// Dialer - opens TCP connection to camera, optional
+	Dialer Dialer
+
+	// Proxy - socks5://, socks5h:// or http:// proxy URL, see NewProxyDialer.
+	// RTSP_PROXY or ALL_PROXY environment variable if empty, ProxyDirect to ignore them.
+	Proxy string
+
+	// Quirks - workarounds for buggy cameras, DefaultQuirks if nil.
+	// Append DefaultQuirks to own quirks to keep them.
+	Quirks []*Quirk
+
+	// RedirectCredentials - send user/pass to another host after redirect,
+	// by default they are kept only for the same host
+	RedirectCredentials bool
 
 	sequence  int
 	auth      *Auth
 	conn      net.Conn
 	session   string
 	keepalive int
+	public    []string
+	redirects int
 	uri       string
 	timeout   time.Duration
 	reader    *bufio.Reader
 	mode      Mode
-	state     State
-	playOK    bool
+	state     atomic.Uint32
+	playOK    atomic.Bool
+	playSeq   atomic.Int32 // CSeq of PLAY waiting for response
+	paused    atomic.Bool
+	playRange atomic.Value
+	udp       []*udpConn
+	lastUDP   atomic.Int64
+	goodbye   atomic.Bool
+
+	// interrupted by context cancel, see withContext
+	interrupted atomic.Bool
+
+	// for Stats
+	reconnects atomic.Int32 // by Supervisor, redirects are not counted
+	statsURL   atomic.Value // string
+
+	// matched quirks of camera
+	camera cameraInfo
+	quirk  Quirk
+
+	// identity for RTCP receiver reports
+	ssrc  uint32
+	cname string
+
+	receiversMu sync.RWMutex
+	writeMu     sync.Mutex
+	connMu      sync.Mutex
+	udpMu       sync.Mutex
 }
 
 type State byte
@@ -68,6 +128,8 @@ const (
 
 var (
 	errNotImplemented = errors.New("not implemented")
+
+	ErrGoodbye = errors.New("rtsp: RTCP BYE received")
 )
 
 const (
@@ -216,10 +278,17 @@ func NewClient(uri string) *Client {
 }
 
 func (c *Client) Dial() (err error) {
-	c.conn = nil
+	return c.DialContext(context.Background())
+}
+
+// DialContext connects to server, ctx aborts TCP connect and TLS handshake
+func (c *Client) DialContext(ctx context.Context) (err error) {
+	c.setConn(nil)
+
 	if c.URL, err = url.Parse(c.uri); err != nil {
 		return err
 	}
+	c.statsURL.Store(redactURL(c.URL))
 	var address string
 	var hostname string // without port
 	if i := strings.IndexByte(c.URL.Host, ':'); i > 0 {
@@ -229,61 +298,108 @@ func (c *Client) Dial() (err error) {
 		switch c.URL.Scheme {
 		case "rtsp", "rtsps", "rtspx":
 			address = c.URL.Host + ":554"
-		case "rtmp":
-			address = c.URL.Host + ":1935"
-		case "rtmps", "rtmpx":
-			address = c.URL.Host + ":443"
+		case "rtsp+http":
+			address = c.URL.Host + ":80"
 		}
 		hostname = c.URL.Host
 	}
 	var secure *tls.Config
 
 	switch c.URL.Scheme {
-	case "rtsp", "rtmp":
-	case "rtsps", "rtspx", "rtmps", "rtmpx":
-		if c.URL.Scheme[4] == 'x' || IsIP(hostname) {
-			secure = &tls.Config{InsecureSkipVerify: true}
-		} else {
-			secure = &tls.Config{ServerName: hostname}
-		}
+	case "rtsp", "rtsp+http":
+	case "rtsps", "rtspx":
+		secure = TLSConfig(c.TLS, c.URL.Scheme, hostname)
+	case "rtmp", "rtmps", "rtmpx":
+		return errors.New("rtsp: use rtmp.Client for scheme: " + c.URL.Scheme)
 	default:
 		return errors.New("unsupported scheme: " + c.URL.Scheme)
 	}
-	conn, err := net.DialTimeout("tcp", address, c.timeout)
+	dialer, err := c.dialer(hostname)
 	if err != nil {
 		return err
 	}
 
-	if secure == nil {
-		c.conn = conn
+	// timeout also limits proxy handshake
+	dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var conn net.Conn
+	if c.URL.Scheme == "rtsp+http" {
+		// RTSP-over-HTTP tunnel, requests inside use usual scheme
+		conn, err = dialTunnel(dialCtx, dialer, address, c.URL, c.timeout)
+		c.URL.Scheme = "rtsp"
 	} else {
+		conn, err = dialer.DialContext(dialCtx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	if secure != nil {
 		tlsConn := tls.Client(conn, secure)
-		if err = tlsConn.Handshake(); err != nil {
+		_ = conn.SetDeadline(time.Now().Add(c.timeout))
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
 			return err
 		}
+		_ = conn.SetDeadline(time.Time{})
 
 		if c.URL.Scheme[4] == 'x' {
 			c.URL.Scheme = c.URL.Scheme[:4] + "s"
 		}
-		c.conn = tlsConn
+
+		// all requests and media go through TLS session
+		conn = tlsConn
 	}
 
 	// remove UserInfo from URL
 	c.auth = NewAuth(c.URL.User)
 	c.URL.User = nil
-	c.conn = conn
+	c.setConn(conn)
 	c.reader = bufio.NewReaderSize(conn, BufferSize)
 	c.session = ""
 	c.sequence = 0
-	c.state = StateConn
+	c.playOK.Store(false)
+	c.playSeq.Store(0)
+	c.paused.Store(false)
+	c.goodbye.Store(false)
+	c.interrupted.Store(false)
+	c.setState(StateConn)
 	return nil
 }
 
+func (c *Client) setConn(conn net.Conn) {
+	c.connMu.Lock()
+	c.conn = conn
+	c.connMu.Unlock()
+}
+
+// abort closes connection from another goroutine, so blocked Handle or Do
+// return with error
+func (c *Client) abort() {
+	c.connMu.Lock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.connMu.Unlock()
+}
+
+// State returns current session state
+func (c *Client) State() State {
+	return State(c.state.Load())
+}
+
+func (c *Client) setState(state State) {
+	c.state.Store(uint32(state))
+}
+
 func (c *Client) Close() error {
-	if c.mode == ModeActiveProducer {
+	if c.mode == ModeActiveProducer || c.mode == ModeActiveConsumer {
 		_ = c.Teardown()
 	}
 
+	c.closeUDP()
+
 	if c.conn == nil {
 		return nil
 	}
@@ -302,30 +418,32 @@ func (c *Client) Reconnect() error {
 	if err := c.Describe(); err != nil {
 		return err
 	}
-	media := Media{Kind: "video", Direction: "recvonly", Codecs: []*Codec{
-		{
-			Name: "H264",
-		},
-	}}
+
 	// restore previous medias
-	if _, err := c.SetupMedia(&media); err != nil {
-		return err
+	for _, media := range c.setupMedias() {
+		if _, err := c.SetupMedia(media); err != nil {
+			return err
+		}
 	}
 
-	// for _, receiver := range c.Receivers {
-	// 	if _, err := c.SetupMedia(receiver.Media); err != nil {
-	// 		return err
-	// 	}
-	// }
-	// for _, sender := range c.Senders {
-	// 	if _, err := c.SetupMedia(sender.Media); err != nil {
-	// 		return err
-	// 	}
-	// }
-
 	return nil
 }
 
+// setupMedias returns medias of all receivers and senders
+func (c *Client) setupMedias() []*Media {
+	c.receiversMu.RLock()
+	defer c.receiversMu.RUnlock()
+
+	var medias []*Media
+	for _, receiver := range c.Receivers {
+		medias = append(medias, receiver.Media)
+	}
+	for _, sender := range c.Senders {
+		medias = append(medias, sender.Media)
+	}
+	return medias
+}
+
 func IsIP(hostname string) bool {
 	return net.ParseIP(hostname) != nil
 }
@@ -338,8 +456,10 @@ func (c *Client) Options() (err error) {
 		return err
 	}
 
+	c.readPublic(res)
+
 	if val := res.Header.Get("Content-Base"); val != "" {
-		c.URL, err = urlParse(val)
+		c.URL, err = c.parseURL(val)
 		if err != nil {
 			return err
 		}
@@ -374,8 +494,10 @@ func (c *Client) Describe() (err error) {
 		return err
 	}
 
+	c.readPublic(res)
+
 	if val := res.Header.Get("Content-Base"); val != "" {
-		c.URL, err = urlParse(val)
+		c.URL, err = c.parseURL(val)
 		if err != nil {
 			return err
 		}
@@ -383,11 +505,16 @@ func (c *Client) Describe() (err error) {
 
 	c.SDP = string(res.Body) // for info
 
-	medias, err := UnmarshalSDP(res.Body)
+	sd, err := parseSDP(res.Body)
 	if err != nil {
 		return err
 	}
 
+	c.camera.origin = sd.Origin.Username
+	c.updateQuirks(nil)
+
+	medias := unmarshalMedias(sd, &c.quirk)
+
 	if c.Media != "" {
 		clone := make([]*Media, 0, len(medias))
 		for _, media := range medias {
@@ -441,40 +568,71 @@ func (c *Client) Record() (err error) {
 	return
 }
 
+// SetupMedia returns RTP channel of media. Incoming packets of recvonly and
+// sendrecv medias are delivered by Receiver with the same channel, sendonly
+// medias (ONVIF backchannel) are written by Sender.
 func (c *Client) SetupMedia(media *Media) (byte, error) {
-	var transport string
+	channel, err := c.setupMedia(media)
+	if err != nil {
+		return 0, err
+	}
+
+	if media.Direction == DirectionSendonly || c.mode == ModeActiveConsumer {
+		c.addSender(media, channel)
+	} else {
+		c.addReceiver(media, channel)
+	}
+
+	c.setState(StateSetup)
+
+	return channel, nil
+}
+
+func (c *Client) setupMedia(media *Media) (byte, error) {
+	var channel = -1
 
 	// try to use media position as channel number
 	for i, m := range c.Medias {
-		fmt.Printf("Media received %v [%s]\n", m.String(), m.ID)
-		fmt.Printf("Media in %v [%s] %v\n", media.String(), media.ID, m.Equal(media))
 		if m.Equal(media) {
-			transport = fmt.Sprintf(
-				// i   - RTP (data channel)
-				// i+1 - RTCP (control channel)
-				"RTP/AVP/TCP;unicast;interleaved=%d-%d", i*2, i*2+1,
-			)
+			// i   - RTP (data channel)
+			// i+1 - RTCP (control channel)
+			channel = i * 2
 			break
 		}
 	}
 
-	if transport == "" {
+	if channel < 0 {
 		return 0, fmt.Errorf("wrong media: %v", media)
 	}
 
-	rawURL := media.ID // control
-	if !strings.Contains(rawURL, "://") {
-		rawURL = c.URL.String()
-		if !strings.HasSuffix(rawURL, "/") {
-			rawURL += "/"
+	var transport string
+	var udp *udpConn
+
+	switch c.Transport {
+	case TransportUDP:
+		rtpConn, rtcpConn, err := ListenUDPPair(nil)
+		if err != nil {
+			return 0, err
 		}
-		rawURL += media.ID
-	} else if strings.HasPrefix(rawURL, "rtsp://rtsp://") {
-		// fix https://github.com/AlexxIT/go2rtc/issues/830
-		rawURL = rawURL[7:]
+		udp = &udpConn{channel: byte(channel), rtp: rtpConn, rtcp: rtcpConn}
+
+		port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+		transport = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d", port, port+1)
+	case TransportMulticast:
+		transport = "RTP/AVP;multicast"
+	default:
+		transport = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1)
 	}
-	trackURL, err := urlParse(rawURL)
+
+	if c.mode == ModeActiveConsumer {
+		transport += ";mode=record"
+	}
+
+	trackURL, err := c.parseURL(c.controlURL(media.ID))
 	if err != nil {
+		if udp != nil {
+			_ = udp.Close()
+		}
 		return 0, err
 	}
 
@@ -488,14 +646,22 @@ func (c *Client) SetupMedia(media *Media) (byte, error) {
 
 	res, err := c.Do(req)
 	if err != nil {
-		// some Dahua/Amcrest cameras fail here because two simultaneous
-		// backchannel connections
-		if c.Backchannel {
+		if udp != nil {
+			_ = udp.Close()
+		}
+
+		// server doesn't support UDP or multicast, fallback to TCP interleaved
+		if c.Transport != TransportTCP && c.Transport != "" && res != nil && res.StatusCode == UnsupportedTransport {
+			c.Transport = TransportTCP
+			return c.setupMedia(media)
+		}
+
+		if c.Backchannel && c.quirk.BackchannelRetry {
 			c.Backchannel = false
 			if err = c.Reconnect(); err != nil {
 				return 0, err
 			}
-			return c.SetupMedia(media)
+			return c.setupMedia(media)
 		}
 
 		return 0, err
@@ -521,27 +687,49 @@ func (c *Client) SetupMedia(media *Media) (byte, error) {
 	// Transport: RTP/AVP/TCP;unicast;interleaved=10-11;ssrc=10117CB7
 	// Transport: RTP/AVP/TCP;unicast;destination=192.168.1.111;source=192.168.1.222;interleaved=0
 	// Transport: RTP/AVP/TCP;ssrc=22345682;interleaved=0-1
+	// Transport: RTP/AVP;unicast;client_port=5000-5001;server_port=6970-6971;source=192.168.1.222
+	// Transport: RTP/AVP;multicast;destination=239.0.0.1;port=5000-5001;ttl=16
 	transport = res.Header.Get("Transport")
-	if !strings.HasPrefix(transport, "RTP/AVP/TCP;") {
-		// Escam Q6 has a bug:
-		// Transport: RTP/AVP;unicast;destination=192.168.1.111;source=192.168.1.222;interleaved=0-1
-		if !strings.Contains(transport, ";interleaved=") {
-			return 0, fmt.Errorf("wrong transport: %s", transport)
+	th, err := ParseTransportHeader(transport)
+	if err != nil {
+		if udp != nil {
+			_ = udp.Close()
 		}
+		return 0, err
 	}
 
-	channel := Between(transport, "interleaved=", "-")
-	i, err := strconv.Atoi(channel)
-	if err != nil {
-		return 0, err
+	if udp != nil {
+		if th.Interleaved == nil {
+			c.setupUDP(th, udp)
+			return udp.channel, nil
+		}
+
+		// some servers ignore our UDP request and answer with interleaved
+		_ = udp.Close()
 	}
 
-	return byte(i), nil
+	if th.Multicast {
+		if err = c.setupMulticast(th, byte(channel)); err != nil {
+			return 0, err
+		}
+		return byte(channel), nil
+	}
+
+	switch {
+	case c.quirk.Interleaved == InterleavedIgnore:
+		return byte(channel), nil
+	case th.Interleaved == nil:
+		if c.quirk.Interleaved == InterleavedRequest {
+			return byte(channel), nil
+		}
+		return 0, fmt.Errorf("wrong transport: %s", transport)
+	}
+
+	return byte(th.Interleaved[0]), nil
 }
 
 func (c *Client) Play() (err error) {
-	req := &Request{Method: PLAY, URL: c.URL}
-	return c.WriteRequest(req)
+	return c.PlayWith(nil)
 }
 
 func (c *Client) Teardown() (err error) {
@@ -572,7 +760,10 @@ type Response struct {
 func (r *Request) String() string {
 	s := r.Method + " " + r.URL.String() + " " + r.Proto + EndLine
 	for k, v := range r.Header {
-		s += k + ": " + v[0] + EndLine
+		// some headers may repeat, for example multiple WWW-Authenticate challenges
+		for _, value := range v {
+			s += k + ": " + value + EndLine
+		}
 	}
 	s += EndLine
 	if r.Body != nil {
@@ -589,7 +780,10 @@ func (r *Request) Write(w io.Writer) (err error) {
 func (r Response) String() string {
 	s := r.Proto + " " + r.Status + EndLine
 	for k, v := range r.Header {
-		s += k + ": " + v[0] + EndLine
+		// some headers may repeat, for example multiple WWW-Authenticate challenges
+		for _, value := range v {
+			s += k + ": " + value + EndLine
+		}
 	}
 	s += EndLine
 	if r.Body != nil {
@@ -610,11 +804,20 @@ func (c *Client) WriteRequest(req *Request) (err error) {
 	if req.Header == nil {
 		req.Header = make(map[string][]string)
 	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
 	c.sequence++
 	// important to send case sensitive CSeq
 	// https://github.com/AlexxIT/go2rtc/issues/7
 	req.Header["CSeq"] = []string{strconv.Itoa(c.sequence)}
 
+	if req.Method == PLAY {
+		// before write, Handle may read response before WriteRequest returns
+		c.playSeq.Store(int32(c.sequence))
+	}
+
 	c.auth.Write(req)
 
 	if c.session != "" {
@@ -626,7 +829,7 @@ func (c *Client) WriteRequest(req *Request) (err error) {
 		req.Header.Set("Content-Length", val)
 	}
 
-	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+	if err := c.setWriteDeadline(time.Now().Add(c.timeout)); err != nil {
 		return err
 	}
 
@@ -635,7 +838,7 @@ func (c *Client) WriteRequest(req *Request) (err error) {
 }
 
 func (c *Client) ReadRequest() (*Request, error) {
-	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+	if err := c.setReadDeadline(time.Now().Add(c.timeout)); err != nil {
 		return nil, err
 	}
 
@@ -666,13 +869,8 @@ func (c *Client) ReadRequest() (*Request, error) {
 		return nil, err
 	}
 
-	if val := req.Header.Get("Content-Length"); val != "" {
-		var i int
-		i, err = strconv.Atoi(val)
-		req.Body = make([]byte, i)
-		if _, err = io.ReadAtLeast(c.reader, req.Body, i); err != nil {
-			return nil, err
-		}
+	if req.Body, err = c.readBody(req.Header); err != nil {
+		return nil, err
 	}
 
 	return req, nil
@@ -711,15 +909,11 @@ func (c *Client) WriteResponse(res *Response) (err error) {
 		res.Header.Set("Content-Length", val)
 	}
 
-	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
-		return err
-	}
-
-	return res.Write(c.conn)
+	return c.write([]byte(res.String()))
 }
 
 func (c *Client) ReadResponse() (*Response, error) {
-	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+	if err := c.setReadDeadline(time.Now().Add(c.timeout)); err != nil {
 		return nil, err
 	}
 	tp := textproto.NewReader(c.reader)
@@ -752,18 +946,32 @@ func (c *Client) ReadResponse() (*Response, error) {
 		return nil, err
 	}
 
-	if val := res.Header.Get("Content-Length"); val != "" {
-		var i int
-		i, err = strconv.Atoi(val)
-		res.Body = make([]byte, i)
-		if _, err = io.ReadAtLeast(c.reader, res.Body, i); err != nil {
-			return nil, err
-		}
+	if res.Body, err = c.readBody(res.Header); err != nil {
+		return nil, err
 	}
 
 	return res, nil
 }
 
+// readBody reads body of Content-Length size, which is limited by BufferSize
+func (c *Client) readBody(header textproto.MIMEHeader) ([]byte, error) {
+	val := header.Get("Content-Length")
+	if val == "" {
+		return nil, nil
+	}
+
+	size, err := strconv.Atoi(val)
+	if err != nil || size < 0 || size > BufferSize {
+		return nil, fmt.Errorf("wrong Content-Length: %s", val)
+	}
+
+	body := make([]byte, size)
+	if _, err = io.ReadFull(c.reader, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
 // Do send WriteRequest and receive and process WriteResponse
 func (c *Client) Do(req *Request) (*Response, error) {
 	if err := c.WriteRequest(req); err != nil {
@@ -775,10 +983,13 @@ func (c *Client) Do(req *Request) (*Response, error) {
 		return nil, err
 	}
 
+	c.updateQuirks(res)
+
 	if res.StatusCode == http.StatusUnauthorized {
 		switch c.auth.Method {
 		case AuthNone:
-			if c.auth.ReadNone(res) {
+			if c.quirk.AuthStyle == AuthStyleTPLink {
+				c.auth.Method = AuthTPLink
 				return c.Do(req)
 			}
 			return nil, errors.New("user/pass not provided")
@@ -786,18 +997,43 @@ func (c *Client) Do(req *Request) (*Response, error) {
 			if c.auth.Read(res) {
 				return c.Do(req)
 			}
+		case AuthDigest:
+			// server may expire nonce and send new challenge with stale=true
+			if c.auth.IsStale(res) && c.auth.Read(res) {
+				return c.Do(req)
+			}
+			return nil, errors.New("wrong user/pass")
 		default:
 			return nil, errors.New("wrong user/pass")
 		}
 	}
 
+	if isRedirect(res.StatusCode) {
+		return c.doRedirect(req, res)
+	}
+
 	if res.StatusCode != http.StatusOK {
 		return res, fmt.Errorf("wrong response on %s", req.Method)
 	}
 
+	c.redirects = 0
+
 	return res, nil
 }
 
+// controlURL returns absolute URL of media control, relative one is added to session URL
+func (c *Client) controlURL(control string) string {
+	if strings.Contains(control, "://") {
+		return control
+	}
+
+	rawURL := c.URL.String()
+	if !strings.HasSuffix(rawURL, "/") {
+		rawURL += "/"
+	}
+	return rawURL + control
+}
+
 func (c *Client) Handle() (err error) {
 	var timeout time.Duration
 
@@ -815,8 +1051,7 @@ func (c *Client) Handle() (err error) {
 			// polling frames from remote RTSP Server (ex Camera)
 			timeout = time.Second * 5
 
-			// if len(c.Receivers) == 0
-			if false {
+			if len(c.Receivers) == 0 {
 				// if we only send audio to camera
 				// https://github.com/AlexxIT/go2rtc/issues/659
 				timeout += keepaliveDT
@@ -833,15 +1068,62 @@ func (c *Client) Handle() (err error) {
 		}
 	case ModePassiveConsumer:
 		// pushing frames to remote RTSP Client (ex VLC)
-		timeout = time.Second * 60
+		if c.Timeout == 0 {
+			timeout = time.Second * 60
+		} else {
+			timeout = time.Second * time.Duration(c.Timeout)
+		}
+	case ModeActiveConsumer:
+		// pushing frames to remote RTSP Server (ex MediaMTX), it sends only
+		// responses and RTCP, so connection is idle between keepalives
+		if c.keepalive > 5 {
+			keepaliveDT = time.Duration(c.keepalive-5) * time.Second
+		} else {
+			keepaliveDT = 25 * time.Second
+		}
+		keepaliveTS = time.Now().Add(keepaliveDT)
+		timeout = keepaliveDT
 	default:
 		return fmt.Errorf("wrong RTSP conn mode: %d", c.mode)
 	}
 
-	for c.state != StateNone {
+	// receiver reports keep session alive on cameras that check RTCP
+	var reportTS, dataDeadline time.Time
+	if c.mode == ModeActiveProducer && len(c.Receivers) > 0 {
+		reportTS = time.Now().Add(reportInterval(true))
+	}
+
+	if c.hasUDP() {
+		c.startUDP()
+	}
+
+	for c.State() != StateNone {
+		if c.goodbye.Load() {
+			// BYE received by UDP reader
+			return ErrGoodbye
+		}
+
 		ts := time.Now()
 
-		if err = c.conn.SetReadDeadline(ts.Add(timeout)); err != nil {
+		// paused NVR playback sends nothing until next PLAY
+		idle := c.hasUDP() || c.mode == ModeActiveConsumer || c.paused.Load()
+
+		// wake up for RTCP report doesn't extend waiting for data
+		if idle || dataDeadline.IsZero() {
+			dataDeadline = ts.Add(timeout)
+		}
+
+		deadline := dataDeadline
+		if idle && keepaliveDT != 0 && keepaliveTS.Before(deadline) {
+			// media goes through UDP or we only send, so control connection
+			// is idle until keepalive
+			deadline = keepaliveTS
+		}
+		if !reportTS.IsZero() && reportTS.Before(deadline) {
+			deadline = reportTS
+		}
+
+		if err = c.setReadDeadline(deadline); err != nil {
 			return
 		}
 
@@ -852,36 +1134,99 @@ func (c *Client) Handle() (err error) {
 		var buf4 []byte // `$` + 1B channel number + 2B size
 		buf4, err = c.reader.Peek(4)
 		if err != nil {
-			fmt.Printf("error %v", err)
+			if isTimeout(err) && (idle || time.Now().Before(dataDeadline)) {
+				// we wait for UDP media only as producer, player may send nothing
+				if c.hasUDP() && (c.mode == ModeActiveProducer || c.mode == ModePassiveProducer) && !c.paused.Load() {
+					if d := c.udpIdle(); d > timeout {
+						return fmt.Errorf("rtsp: no UDP packets for %s", d)
+					}
+				}
+
+				if ts = time.Now(); keepaliveDT != 0 && ts.After(keepaliveTS) {
+					if err = c.sendKeepalive(); err != nil {
+						return
+					}
+					keepaliveTS = ts.Add(keepaliveDT)
+				}
+
+				if !reportTS.IsZero() && ts.After(reportTS) {
+					if err = c.sendReceiverReports(); err != nil {
+						return
+					}
+					reportTS = ts.Add(reportInterval(false))
+				}
+				continue
+			}
+
 			return
 		}
 
+		dataDeadline = time.Time{}
+
 		var channelID byte
 		var size uint16
 
 		if buf4[0] != '$' {
 			switch string(buf4) {
 			case "RTSP":
-				// var res *Response
-				if _, err = c.ReadResponse(); err != nil {
+				var res *Response
+				if res, err = c.ReadResponse(); err != nil {
 					return
 				}
 
-				// for playing backchannel only after OK response on play
-				c.playOK = true
+				if err = c.handlePlayResponse(res); err != nil {
+					return
+				}
 				continue
 
-			case "OPTI", "TEAR", "DESC", "SETU", "PLAY", "PAUS", "RECO", "ANNO", "GET_", "SET_":
+			case "OPTI", "TEAR", "DESC", "SETU", "PLAY", "PAUS", "RECO", "ANNO", "GET_", "SET_", "REDI":
 				var req *Request
 				if req, err = c.ReadRequest(); err != nil {
 					return
 				}
 
-				if req.Method == OPTIONS {
+				switch req.Method {
+				case OPTIONS, GET_PARAMETER, SET_PARAMETER:
+					// keepalive from remote client or server
 					res := &Response{Request: req}
 					if err = c.WriteResponse(res); err != nil {
 						return
 					}
+				case TEARDOWN:
+					if c.mode == ModePassiveConsumer || c.mode == ModePassiveProducer {
+						res := &Response{Request: req}
+						err = c.WriteResponse(res)
+						c.setState(StateNone)
+						return
+					}
+				case REDIRECT:
+					if c.mode == ModeActiveProducer {
+						res := &Response{Request: req}
+						if err = c.WriteResponse(res); err != nil {
+							return
+						}
+						if err = c.handleRedirect(req); err != nil {
+							return
+						}
+					}
+				case PLAY:
+					if c.mode == ModePassiveConsumer {
+						// repeated PLAY, stream is already going
+						res := &Response{Request: req}
+						if err = c.WriteResponse(res); err != nil {
+							return
+						}
+						break
+					}
+					fallthrough
+				default:
+					if c.mode == ModePassiveConsumer || c.mode == ModePassiveProducer {
+						// PAUSE, SETUP and others are not supported in the middle of session
+						res := NewResponse(req, MethodNotValidInThisState)
+						if err = c.WriteResponse(res); err != nil {
+							return
+						}
+					}
 				}
 				continue
 
@@ -938,42 +1283,76 @@ func (c *Client) Handle() (err error) {
 			return
 		}
 
-		if channelID&1 == 0 {
-			packet := &rtp.Packet{}
-			if err = packet.Unmarshal(buf); err != nil {
+		if err = c.handleData(channelID, buf); err != nil {
+			return
+		}
+
+		if keepaliveDT != 0 && ts.After(keepaliveTS) {
+			if err = c.sendKeepalive(); err != nil {
 				return
 			}
-			fmt.Println(packet)
-
-			// for _, receiver := range c.Receivers {
-			// 	if receiver.ID == channelID {
-			// 		receiver.WriteRTP(packet)
-			// 		break
-			// 	}
-			// }
-		} else {
-			msg := &RTCP{Channel: channelID}
 
-			if err = msg.Header.Unmarshal(buf); err != nil {
-				continue
-			}
+			keepaliveTS = ts.Add(keepaliveDT)
+		}
 
-			msg.Packets, err = rtcp.Unmarshal(buf)
-			if err != nil {
-				continue
+		if !reportTS.IsZero() && ts.After(reportTS) {
+			if err = c.sendReceiverReports(); err != nil {
+				return
 			}
+			reportTS = ts.Add(reportInterval(false))
+		}
+	}
+
+	return
+}
 
+// handleData process RTP (even channels) and RTCP (odd channels) packets
+// from interleaved TCP stream or UDP sockets
+func (c *Client) handleData(channelID byte, buf []byte) error {
+	if channelID&1 == 0 {
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(buf); err != nil {
+			return err
 		}
 
-		if keepaliveDT != 0 && ts.After(keepaliveTS) {
-			req := &Request{Method: OPTIONS, URL: c.URL}
-			if err = c.WriteRequest(req); err != nil {
-				return
-			}
+		if receiver := c.Receiver(channelID); receiver != nil {
+			captureTime := receiver.updateStats(packet, time.Now())
+			receiver.writeRTP(&capturedPacket{packet: packet, captureTime: captureTime})
+		}
+		return nil
+	}
 
-			keepaliveTS = ts.Add(keepaliveDT)
+	msg := &RTCP{Channel: channelID}
+
+	if err := msg.Header.Unmarshal(buf); err != nil {
+		return nil
+	}
+
+	var err error
+	if msg.Packets, err = rtcp.Unmarshal(buf); err != nil {
+		return nil
+	}
+
+	receiver := c.Receiver(channelID - 1)
+	if receiver != nil {
+		receiver.WriteRTCP(msg)
+	}
+
+	for _, packet := range msg.Packets {
+		switch packet := packet.(type) {
+		case *rtcp.SenderReport:
+			if receiver != nil {
+				receiver.onSenderReport(packet, time.Now())
+			}
+		case *rtcp.Goodbye:
+			return ErrGoodbye
 		}
 	}
 
-	return
+	return nil
+}
+
+func (c *Client) sendKeepalive() error {
+	req := &Request{Method: c.keepaliveMethod(), URL: c.URL}
+	return c.WriteRequest(req)
 }
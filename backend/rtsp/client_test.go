@@ -1 +1,294 @@
 package rtsp
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+	"github.com/vtpl1/phoring/backend/rtsp/rtsptest"
+)
+
+func TestClientPublish(t *testing.T) {
+	srv, address := startTestServer(t)
+
+	client := NewClient("rtsp://" + address + "/push")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	senders, err := client.Publish(
+		&Media{Kind: KindVideo, Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}}},
+		&Media{Kind: KindAudio, Codecs: []*Codec{{Name: CodecPCMU, ClockRate: 8000}}},
+	)
+	require.NoError(t, err)
+	require.Len(t, senders, 2)
+	require.Equal(t, byte(0), senders[0].Channel)
+	require.Equal(t, byte(2), senders[1].Channel)
+
+	go func() {
+		_ = client.Handle()
+	}()
+
+	st := srv.Stream("push")
+	require.NotNil(t, st)
+	require.Len(t, st.Receivers, 2)
+
+	video := make(chan *rtp.Packet, 10)
+	st.Receivers[0].AddHandler(&Handler{OnRTP: func(packet *rtp.Packet) { video <- packet }})
+	audio := make(chan *rtp.Packet, 10)
+	st.Receivers[1].AddHandler(&Handler{OnRTP: func(packet *rtp.Packet) { audio <- packet }})
+
+	// IDR frame in Annex B format
+	require.NoError(t, senders[0].WriteSample([]byte{0, 0, 0, 1, 0x65, 1, 2, 3}, 3000))
+	_, err = senders[1].Write(make([]byte, 160))
+	require.NoError(t, err)
+
+	select {
+	case packet := <-video:
+		require.Equal(t, uint8(96), packet.PayloadType)
+		require.Equal(t, []byte{0x65, 1, 2, 3}, packet.Payload)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no video")
+	}
+
+	select {
+	case packet := <-audio:
+		require.Equal(t, uint8(0), packet.PayloadType)
+		require.Len(t, packet.Payload, 160)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no audio")
+	}
+}
+
+func TestClientPublishPayloadType(t *testing.T) {
+	srv, address := startTestServer(t)
+
+	client := NewClient("rtsp://" + address + "/push")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	h264 := &Codec{Name: CodecH264, ClockRate: 90000}
+	_, err := client.Publish(
+		&Media{Kind: KindVideo, Codecs: []*Codec{h264}},
+		&Media{Kind: KindAudio, Codecs: []*Codec{{Name: CodecAAC, ClockRate: 16000}}},
+		&Media{Kind: KindAudio, Codecs: []*Codec{{Name: CodecPCMA, ClockRate: 8000}}},
+		&Media{Kind: KindAudio, Codecs: []*Codec{{Name: CodecOpus, ClockRate: 48000, Channels: 2, PayloadType: 96}}},
+	)
+	require.NoError(t, err)
+
+	// H264 with zero type must not go out as PCMU
+	var types []uint8
+	for _, receiver := range srv.Stream("push").Receivers {
+		types = append(types, receiver.Codec.PayloadType)
+	}
+	require.Equal(t, []uint8{97, 98, 8, 96}, types)
+	require.Zero(t, h264.PayloadType)
+
+	medias := make([]*Media, 33)
+	for i := range medias {
+		medias[i] = &Media{Kind: KindVideo, Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000}}}
+	}
+	require.Error(t, assignPayloadTypes(medias))
+}
+
+func startTestCamera(t *testing.T, setup func(cam *rtsptest.Camera)) *rtsptest.Camera {
+	cam, err := rtsptest.LoadCamera("testdata/camera.sdp", "testdata/video.rtpdump", "testdata/audio.rtpdump")
+	require.NoError(t, err)
+
+	if setup != nil {
+		setup(cam)
+	}
+
+	require.NoError(t, cam.Start())
+	t.Cleanup(func() {
+		_ = cam.Close()
+	})
+
+	return cam
+}
+
+// playTestCamera setups all medias, plays and counts packets of each track
+func playTestCamera(t *testing.T, client *Client) ([]*atomic.Int32, chan error) {
+	require.NoError(t, client.Dial())
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	require.NoError(t, client.Describe())
+	require.Len(t, client.Medias, 2)
+
+	counters := make([]*atomic.Int32, len(client.Medias))
+	for i, media := range client.Medias {
+		channel, err := client.SetupMedia(media)
+		require.NoError(t, err)
+
+		counter := &atomic.Int32{}
+		client.Receiver(channel).AddHandler(&Handler{
+			OnRTP: func(packet *rtp.Packet) { counter.Add(1) },
+		})
+		counters[i] = counter
+	}
+
+	require.NoError(t, client.Play())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Handle()
+	}()
+
+	return counters, done
+}
+
+func TestClientCamera(t *testing.T) {
+	for _, test := range []struct {
+		Name      string
+		Setup     func(cam *rtsptest.Camera)
+		Transport string
+		User      string
+	}{
+		{Name: "replay"},
+		{Name: "udp fallback", Transport: TransportUDP},
+		{
+			Name:  "digest",
+			Setup: func(cam *rtsptest.Camera) { cam.Username, cam.Password = "admin", "secret" },
+			User:  "admin:secret@",
+		},
+		{
+			Name: "basic",
+			Setup: func(cam *rtsptest.Camera) {
+				cam.Username, cam.Password, cam.AuthMethod = "admin", "secret", rtsptest.AuthBasic
+			},
+			User: "admin:secret@",
+		},
+		{
+			Name:  "junk between frames",
+			Setup: func(cam *rtsptest.Camera) { cam.Junk = []byte{0, 1, 2, 3, 4, 5} },
+		},
+		{
+			// Escam Q6 answers without interleaved
+			Name: "escam transport",
+			Setup: func(cam *rtsptest.Camera) {
+				cam.Transport = func(requested string, track int) string {
+					return "RTP/AVP;unicast;destination=127.0.0.1;source=127.0.0.1"
+				}
+			},
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			cam := startTestCamera(t, test.Setup)
+
+			client := NewClient(strings.Replace(cam.URL, "rtsp://", "rtsp://"+test.User, 1))
+			client.Transport = test.Transport
+
+			counters, _ := playTestCamera(t, client)
+
+			require.Eventually(t, func() bool {
+				return counters[0].Load() == 31 && counters[1].Load() == 50
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestClientCameraErrors(t *testing.T) {
+	cam := startTestCamera(t, func(cam *rtsptest.Camera) {
+		cam.Username, cam.Password = "admin", "secret"
+	})
+
+	client := NewClient(strings.Replace(cam.URL, "rtsp://", "rtsp://admin:wrong@", 1))
+	require.NoError(t, client.Dial())
+	require.Error(t, client.Describe())
+	_ = client.Close()
+
+	// without quirks answer without interleaved is an error
+	cam = startTestCamera(t, func(cam *rtsptest.Camera) {
+		cam.Transport = func(requested string, track int) string {
+			return "RTP/AVP;unicast;destination=127.0.0.1;source=127.0.0.1"
+		}
+	})
+
+	client = NewClient(cam.URL)
+	client.Quirks = []*Quirk{}
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Describe())
+	_, err := client.SetupMedia(client.Medias[0])
+	require.Error(t, err)
+}
+
+func TestClientCameraBye(t *testing.T) {
+	cam := startTestCamera(t, func(cam *rtsptest.Camera) { cam.ByeAfter = 10 })
+
+	counters, done := playTestCamera(t, NewClient(cam.URL))
+
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, ErrGoodbye)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no BYE")
+	}
+
+	// handlers are async, last packets may be in queue
+	require.Eventually(t, func() bool {
+		return counters[0].Load()+counters[1].Load() == 9
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClientCameraSessionTimeout(t *testing.T) {
+	cam := startTestCamera(t, func(cam *rtsptest.Camera) {
+		cam.Loop = true
+		cam.SessionTimeout = 300 * time.Millisecond
+	})
+
+	// camera drops session without keepalive, supervisor reconnects
+	s := NewSupervisor(NewClient(cam.URL))
+	s.MinBackoff = time.Millisecond
+	defer s.Stop()
+
+	go func() {
+		_ = s.Run()
+	}()
+
+	for plays := 0; plays < 2; {
+		select {
+		case event := <-s.Events():
+			if event.State == StatePlay {
+				plays++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reconnect")
+		}
+	}
+
+	require.GreaterOrEqual(t, s.Client.Stats().Reconnects, 1)
+}
+
+func TestClientCameraQuirks(t *testing.T) {
+	// TP-Link accepts requests with 127.0.0.1 host without credentials
+	cam := startTestCamera(t, func(cam *rtsptest.Camera) {
+		cam.Challenge = `Digest realm="TP-LINK Streaming Media", nonce="1"`
+		cam.Authorize = func(req *rtsptest.Request) bool {
+			return strings.HasPrefix(req.URL, "rtsp://127.0.0.1/")
+		}
+	})
+
+	client := NewClient(cam.URL)
+	require.NoError(t, client.Dial())
+	require.NoError(t, client.Describe())
+	require.Contains(t, client.ActiveQuirks(), "tp-link")
+	_ = client.Close()
+
+	// Content-Base: rtsp://rtsp://
+	cam = startTestCamera(t, nil)
+	cam.ContentBase = strings.Replace(cam.URL, "rtsp://", "rtsp://rtsp://", 1) + "/"
+
+	client = NewClient(cam.URL)
+	counters, _ := playTestCamera(t, client)
+	require.Equal(t, cam.URL+"/", client.URL.String())
+
+	require.Eventually(t, func() bool {
+		return counters[0].Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,106 @@
+package rtsp
+
+import (
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+// ExtAbsCaptureTime - RTP header extension with NTP capture time of the frame
+const ExtAbsCaptureTime = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+
+// wallClock maps RTP timestamps of one source to wall-clock time of the sender.
+// Sender Reports have priority, abs-capture-time is used until first SR.
+type wallClock struct {
+	ntp time.Time // anchor wall-clock time
+	rtp uint32    // anchor RTP time
+	sr  bool      // anchor from Sender Report
+
+	// drift of RTP clock against sender wall clock, from the first SR
+	firstNTP time.Time
+	ticks    int64 // RTP ticks since first SR, without wraparound
+	drift    float64
+}
+
+func (w *wallClock) onSenderReport(ntp time.Time, rtpTime, clockRate uint32) {
+	if !w.sr {
+		w.firstNTP = ntp
+		w.ticks = 0
+	} else {
+		w.ticks += int64(int32(rtpTime - w.rtp))
+
+		if elapsed := ntp.Sub(w.firstNTP); elapsed >= time.Second && clockRate != 0 {
+			w.drift = (float64(w.ticks)/float64(clockRate)/elapsed.Seconds() - 1) * 1e6
+		}
+	}
+
+	w.ntp = ntp
+	w.rtp = rtpTime
+	w.sr = true
+}
+
+func (w *wallClock) onCaptureTime(ts time.Time, rtpTime uint32) {
+	if w.sr {
+		return
+	}
+	w.ntp = ts
+	w.rtp = rtpTime
+}
+
+func (w *wallClock) time(rtpTime, clockRate uint32) (time.Time, bool) {
+	if w.ntp.IsZero() || clockRate == 0 {
+		return time.Time{}, false
+	}
+	d := int64(int32(rtpTime-w.rtp)) * int64(time.Second) / int64(clockRate)
+	return w.ntp.Add(time.Duration(d)), true
+}
+
+// CaptureTime returns sender wall-clock time of packet from RTCP Sender Reports
+// or abs-capture-time extension. Times of all tracks from one camera are on the
+// same timeline, so they can be used for lip-sync. Mapping is moved by each SR,
+// use Handler.OnCapturedRTP to get time of packet at the moment of its arrival.
+func (r *Receiver) CaptureTime(packet *rtp.Packet) (time.Time, bool) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	s := r.sources[packet.SSRC]
+	if s == nil {
+		return time.Time{}, false
+	}
+	return s.clock.time(packet.Timestamp, r.clockRate())
+}
+
+func (r *Receiver) clockRate() uint32 {
+	if r.Codec != nil {
+		return r.Codec.ClockRate
+	}
+	return 0
+}
+
+// updateClock checks packet for abs-capture-time extension
+func (r *Receiver) updateClock(s *rtpSource, packet *rtp.Packet) {
+	if !packet.Extension {
+		return
+	}
+
+	id := r.Media.Extensions[ExtAbsCaptureTime]
+	if id == 0 {
+		return
+	}
+
+	if b := packet.GetExtension(id); b != nil {
+		var ext rtp.AbsCaptureTimeExtension
+		if ext.Unmarshal(b) == nil {
+			s.clock.onCaptureTime(ext.CaptureTime(), packet.Timestamp)
+		}
+	}
+}
+
+// ntpTime converts 64-bit NTP timestamp to time
+func ntpTime(ntp uint64) time.Time {
+	const unixOffset = 0x83AA7E80 // seconds between 1900 and 1970
+
+	sec := int64(ntp>>32) - unixOffset
+	nsec := int64((ntp & 0xFFFFFFFF) * uint64(time.Second) >> 32)
+	return time.Unix(sec, nsec)
+}
@@ -0,0 +1,115 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtcp"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func TestNTPTime(t *testing.T) {
+	require.Equal(t, time.Unix(0, 0), ntpTime(0x83AA7E80<<32))
+	require.Equal(t, time.Unix(1, 500_000_000), ntpTime(0x83AA7E81<<32|0x80000000))
+}
+
+func TestReceiverCaptureTime(t *testing.T) {
+	media := &Media{
+		Kind: KindVideo, Direction: DirectionRecvonly,
+		Codecs:     []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+		Extensions: map[string]uint8{ExtAbsCaptureTime: 3},
+	}
+	r := NewReceiver(media, 0)
+
+	now := time.Now()
+	capture := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	packet := func(seq uint16, ts uint32) *rtp.Packet {
+		return &rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1, SequenceNumber: seq, Timestamp: ts}}
+	}
+
+	p := packet(1, 1000)
+	_, ok := r.CaptureTime(p)
+	require.False(t, ok)
+
+	// abs-capture-time on first packet of frame
+	ext, err := rtp.NewAbsCaptureTimeExtension(capture).Marshal()
+	require.NoError(t, err)
+	require.NoError(t, p.SetExtension(3, ext))
+	r.updateStats(p, now)
+
+	ts, ok := r.CaptureTime(packet(2, 1000+45000))
+	require.True(t, ok)
+	require.WithinDuration(t, capture.Add(500*time.Millisecond), ts, time.Millisecond)
+
+	// sender report has priority
+	srTime := capture.Add(time.Hour)
+	r.onSenderReport(&rtcp.SenderReport{SSRC: 1, NTPTime: toNTP(srTime), RTPTime: 4_294_900_000}, now)
+
+	ts, ok = r.CaptureTime(packet(3, 4_294_900_000-90000))
+	require.True(t, ok)
+	require.WithinDuration(t, srTime.Add(-time.Second), ts, time.Millisecond)
+
+	// RTP clock is 100 ppm faster than wall clock, with wraparound
+	rtpTime := uint32(4_294_900_000)
+	rtpTime += 10 * 90009
+	r.onSenderReport(&rtcp.SenderReport{SSRC: 1, NTPTime: toNTP(srTime.Add(10 * time.Second)), RTPTime: rtpTime}, now)
+
+	ts, ok = r.CaptureTime(packet(4, rtpTime+90000))
+	require.True(t, ok)
+	require.WithinDuration(t, srTime.Add(11*time.Second), ts, time.Millisecond)
+
+	require.InDelta(t, 100, r.Sources()[0].Drift, 1)
+}
+
+func TestClientCapturedRTP(t *testing.T) {
+	c := &Client{}
+	r := c.addReceiver(&Media{
+		Kind: KindVideo, Direction: DirectionRecvonly,
+		Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+	}, 0)
+
+	captured := make(chan time.Time, 2)
+	h := &Handler{OnCapturedRTP: func(packet *rtp.Packet, captureTime time.Time) { captured <- captureTime }}
+	r.AddHandler(h)
+
+	// SR comes before the first RTP packet of source
+	srTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	b, err := rtcp.SenderReport{SSRC: 1, NTPTime: toNTP(srTime), RTPTime: 90000}.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, c.handleData(1, b))
+
+	for _, ts := range []uint32{90000 + 45000, 90000 + 90000} {
+		b, err = (&rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 1, Timestamp: ts}}).Marshal()
+		require.NoError(t, err)
+		require.NoError(t, c.handleData(0, b))
+	}
+
+	require.WithinDuration(t, srTime.Add(500*time.Millisecond), <-captured, time.Millisecond)
+	require.WithinDuration(t, srTime.Add(time.Second), <-captured, time.Millisecond)
+}
+
+func TestMediaExtensions(t *testing.T) {
+	medias, err := UnmarshalSDP([]byte(`v=0
+o=- 1 1 IN IP4 0.0.0.0
+s=-
+t=0 0
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+a=extmap:3 http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time
+a=extmap:5/recvonly urn:ietf:params:rtp-hdrext:sdes:mid
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint8{ExtAbsCaptureTime: 3, "urn:ietf:params:rtp-hdrext:sdes:mid": 5}, medias[0].Extensions)
+
+	b, err := MarshalSDP("", medias)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "a=extmap:3 "+ExtAbsCaptureTime+"\r\na=extmap:5 urn:ietf:params:rtp-hdrext:sdes:mid\r\n")
+}
+
+func toNTP(t time.Time) uint64 {
+	sec := uint64(t.Unix()) + 0x83AA7E80
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
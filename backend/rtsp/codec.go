@@ -27,6 +27,31 @@ func (c *Codec) String() (s string) {
 	return
 }
 
+// staticPayloadType returns payload type of codec from RFC 3551 table,
+// false for codecs with dynamic types
+func staticPayloadType(c *Codec) (uint8, bool) {
+	switch c.Name {
+	case CodecPCMU:
+		return 0, c.ClockRate == 8000 && c.Channels <= 1
+	case CodecPCMA:
+		return 8, c.ClockRate == 8000 && c.Channels <= 1
+	case CodecG722:
+		return 9, c.ClockRate == 8000 && c.Channels <= 1
+	case CodecPCM:
+		if c.ClockRate == 44100 {
+			if c.Channels == 2 {
+				return 10, true
+			}
+			return 11, c.Channels <= 1
+		}
+	case CodecMP3:
+		return 14, c.ClockRate == 90000
+	case CodecJPEG:
+		return 26, c.ClockRate == 90000
+	}
+	return 0, false
+}
+
 func UnmarshalCodec(md *sdp.MediaDescription, payloadType string) *Codec {
 	c := &Codec{PayloadType: byte(Atoi(payloadType))}
 
@@ -77,42 +102,6 @@ func UnmarshalCodec(md *sdp.MediaDescription, payloadType string) *Codec {
 		case "26":
 			c.Name = CodecJPEG
 			c.ClockRate = 90000
-		case "96", "97", "98":
-			if len(md.Bandwidth) == 0 {
-				c.Name = payloadType
-				break
-			}
-
-			// FFmpeg + RTSP + pcm_s16le = doesn't pass info about codec name and params
-			// so try to guess the codec based on bitrate
-			// https://github.com/AlexxIT/go2rtc/issues/523
-			switch md.Bandwidth[0].Bandwidth {
-			case 128:
-				c.ClockRate = 8000
-			case 256:
-				c.ClockRate = 16000
-			case 384:
-				c.ClockRate = 24000
-			case 512:
-				c.ClockRate = 32000
-			case 705:
-				c.ClockRate = 44100
-			case 768:
-				c.ClockRate = 48000
-			case 1411:
-				// default Windows DShow
-				c.ClockRate = 44100
-				c.Channels = 2
-			case 1536:
-				// default Linux ALSA
-				c.ClockRate = 48000
-				c.Channels = 2
-			default:
-				c.Name = payloadType
-				break
-			}
-
-			c.Name = CodecPCML
 		default:
 			c.Name = payloadType
 		}
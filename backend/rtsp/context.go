@@ -0,0 +1,92 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errInterrupted = errors.New("rtsp: interrupted")
+
+// DescribeContext is Describe which can be cancelled by ctx.
+// On cancel connection is closed and ctx.Err() returned.
+func (c *Client) DescribeContext(ctx context.Context) error {
+	return c.withContext(ctx, c.Describe)
+}
+
+// SetupMediaContext is SetupMedia which can be cancelled by ctx
+func (c *Client) SetupMediaContext(ctx context.Context, media *Media) (channel byte, err error) {
+	err = c.withContext(ctx, func() (err error) {
+		channel, err = c.SetupMedia(media)
+		return
+	})
+	return
+}
+
+// PlayContext is Play which can be cancelled by ctx
+func (c *Client) PlayContext(ctx context.Context) error {
+	return c.withContext(ctx, c.Play)
+}
+
+// HandleContext is Handle which stops on ctx cancel, sends TEARDOWN,
+// closes connection and returns ctx.Err()
+func (c *Client) HandleContext(ctx context.Context) error {
+	return c.withContext(ctx, c.Handle)
+}
+
+// withContext runs fn and interrupts blocked reads and writes when ctx is done.
+// Connection stays usable after interrupt, so Close can send TEARDOWN.
+func (c *Client) withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		c.interrupt()
+		close(done)
+	})
+
+	err := fn()
+	if stop() {
+		return err // ctx wasn't cancelled
+	}
+
+	<-done
+	c.interrupted.Store(false)
+	_ = c.Close()
+	return ctx.Err()
+}
+
+// interrupt breaks current and next reads and writes until interrupted flag reset
+func (c *Client) interrupt() {
+	c.interrupted.Store(true)
+
+	c.connMu.Lock()
+	if c.conn != nil {
+		_ = c.conn.SetDeadline(time.Now())
+	}
+	c.connMu.Unlock()
+}
+
+// setReadDeadline doesn't allow to override deadline of interrupted connection:
+// flag is checked after deadline is set, and interrupt sets flag before deadline
+func (c *Client) setReadDeadline(t time.Time) error {
+	if err := c.conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	if c.interrupted.Load() {
+		return errInterrupted
+	}
+	return nil
+}
+
+func (c *Client) setWriteDeadline(t time.Time) error {
+	if err := c.conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	if c.interrupted.Load() {
+		return errInterrupted
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package rtsp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientDescribeContext(t *testing.T) {
+	// server never answers DESCRIBE
+	address := startFakeServer(t, func(c *Client, req *Request) bool {
+		return true
+	})
+
+	client := NewClient("rtsp://" + address + "/camera1")
+	require.NoError(t, client.Dial())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	require.ErrorIs(t, client.DescribeContext(ctx), context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestClientHandleContext(t *testing.T) {
+	srv, _ := startTestServer(t)
+	st := addTestStream(srv)
+
+	sdp, err := MarshalSDP("", st.Medias)
+	require.NoError(t, err)
+
+	teardown := make(chan struct{})
+
+	// camera accepts session but never sends data
+	address := startFakeServer(t, func(c *Client, req *Request) bool {
+		res := NewResponse(req, OK)
+		switch req.Method {
+		case DESCRIBE:
+			res.Header.Set("Content-Type", "application/sdp")
+			res.Body = sdp
+		case SETUP:
+			res.Header.Set("Transport", "RTP/AVP/TCP;unicast;interleaved=0-1")
+			res.Header.Set("Session", "12345")
+		case TEARDOWN:
+			close(teardown)
+			return false
+		}
+		return c.WriteResponse(res) == nil
+	})
+
+	client := NewClient("rtsp://" + address + "/camera1")
+	require.NoError(t, client.DialContext(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	require.NoError(t, client.DescribeContext(ctx))
+	_, err = client.SetupMediaContext(ctx, client.Medias[0])
+	require.NoError(t, err)
+	require.NoError(t, client.PlayContext(ctx))
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.HandleContext(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err = <-errs:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Handle not stopped")
+	}
+
+	select {
+	case <-teardown:
+	case <-time.After(time.Second):
+		require.FailNow(t, "no TEARDOWN")
+	}
+
+	// cancelled context doesn't start new requests
+	require.ErrorIs(t, client.PlayContext(ctx), context.Canceled)
+}
@@ -20,6 +20,7 @@ const (
 const (
 	KindVideo = "video"
 	KindAudio = "audio"
+	KindApp   = "application"
 )
 
 const (
@@ -44,6 +45,8 @@ const (
 	CodecELD  = "ELD" // AAC-ELD
 	CodecFLAC = "FLAC"
 
+	CodecONVIF = "VND.ONVIF.METADATA" // XML analytics and events
+
 	CodecAll = "ALL"
 	CodecAny = "ANY"
 )
@@ -53,7 +56,19 @@ o=- 0 0 IN IP4 0.0.0.0
 s=-
 t=0 0`
 
+// UnmarshalSDP parses medias with DefaultQuirks matched by SDP origin
 func UnmarshalSDP(rawSDP []byte) ([]*Media, error) {
+	sd, err := parseSDP(rawSDP)
+	if err != nil {
+		return nil, err
+	}
+
+	quirk := matchQuirks(DefaultQuirks, &cameraInfo{origin: sd.Origin.Username})
+	return unmarshalMedias(sd, &quirk), nil
+}
+
+// parseSDP parses session description and fixes common errors
+func parseSDP(rawSDP []byte) (*sdp.SessionDescription, error) {
 	sd := &sdp.SessionDescription{}
 	if err := sd.Unmarshal(rawSDP); err != nil {
 		// fix multiple `s=` https://github.com/AlexxIT/WebRTC/issues/417
@@ -87,9 +102,10 @@ func UnmarshalSDP(rawSDP []byte) ([]*Media, error) {
 		}
 	}
 
-	// fix buggy camera https://github.com/AlexxIT/go2rtc/issues/771
-	forceDirection := sd.Origin.Username == "CV-RTSPHandler"
+	return sd, nil
+}
 
+func unmarshalMedias(sd *sdp.SessionDescription, quirk *Quirk) []*Media {
 	var medias []*Media
 
 	for _, md := range sd.MediaDescriptions {
@@ -103,14 +119,16 @@ func UnmarshalSDP(rawSDP []byte) ([]*Media, error) {
 			}
 		}
 
-		if media.Direction == "" || forceDirection {
+		if media.Direction == "" {
 			media.Direction = DirectionRecvonly
 		}
 
 		medias = append(medias, media)
 	}
 
-	return medias, nil
+	quirk.repairMedias(sd, medias)
+
+	return medias
 }
 
 func findFmtpLine(payloadType uint8, descriptions []*sdp.MediaDescription) string {
@@ -124,14 +142,9 @@ func findFmtpLine(payloadType uint8, descriptions []*sdp.MediaDescription) strin
 	return ""
 }
 
-// urlParse fix bugs:
-// 1. Content-Base: rtsp://::ffff:192.168.1.123/onvif/profile.1/
-// 2. Content-Base: rtsp://rtsp://turret2-cam.lan:554/stream1/
+// urlParse fix bug:
+// Content-Base: rtsp://::ffff:192.168.1.123/onvif/profile.1/
 func urlParse(rawURL string) (*url.URL, error) {
-	if strings.HasPrefix(rawURL, "rtsp://rtsp://") {
-		rawURL = rawURL[7:]
-	}
-
 	u, err := url.Parse(rawURL)
 	if err != nil && strings.HasSuffix(err.Error(), "after host") {
 		if i1 := strings.Index(rawURL, "://"); i1 > 0 {
@@ -0,0 +1,403 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+// MaxGOPPackets - limit of cached packets from last keyframe, cache is dropped above it
+const MaxGOPPackets = 4096
+
+var errHubSource = errors.New("rtsp: hub source stopped")
+
+// Hub shares one upstream session per source URL between many consumers.
+// Cameras often allow only a few sessions. Upstream is supervised by Supervisor
+// and closed after Linger when the last consumer leaves.
+type Hub struct {
+	Linger time.Duration // 5 seconds if zero
+
+	// NewClient creates upstream client, for example with custom Transport or TLS
+	NewClient func(uri string) *Client
+
+	mu      sync.Mutex
+	sources map[string]*hubSource
+}
+
+func NewHub() *Hub {
+	return &Hub{sources: map[string]*hubSource{}}
+}
+
+// Subscription - one consumer of Hub source
+type Subscription struct {
+	source   *hubSource
+	tracks   []*hubTrack
+	handlers []*Handler
+	once     sync.Once
+}
+
+// Subscribe waits until upstream plays and adds handler for each track.
+// Handler gets own queue and drop policy, Block is replaced with DropNewest,
+// so a slow consumer doesn't stall others and upstream.
+// Video starts from the last cached keyframe. newHandler may return nil to skip the track.
+func (h *Hub) Subscribe(ctx context.Context, uri string, newHandler func(r *Receiver) *Handler) (*Subscription, error) {
+	s := h.acquire(uri)
+
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		h.release(s)
+		return nil, ctx.Err()
+	}
+
+	if s.err != nil {
+		h.release(s)
+		return nil, s.err
+	}
+
+	sub := &Subscription{source: s}
+
+	for _, track := range s.tracks {
+		handler := newHandler(track.receiver)
+		if handler == nil {
+			continue
+		}
+		track.addConsumer(handler)
+
+		sub.tracks = append(sub.tracks, track)
+		sub.handlers = append(sub.handlers, handler)
+	}
+
+	return sub, nil
+}
+
+// Receivers returns upstream tracks of subscription
+func (s *Subscription) Receivers() []*Receiver {
+	receivers := make([]*Receiver, len(s.tracks))
+	for i, track := range s.tracks {
+		receivers[i] = track.receiver
+	}
+	return receivers
+}
+
+// Close removes handlers of subscription. Already queued packets are still delivered.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		for i, track := range s.tracks {
+			track.removeConsumer(s.handlers[i])
+		}
+		s.source.hub.release(s.source)
+	})
+}
+
+// acquire returns running source for URL and increments its consumers
+func (h *Hub) acquire(uri string) *hubSource {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.sources[uri]
+	if s == nil {
+		newClient := h.NewClient
+		if newClient == nil {
+			newClient = NewClient
+		}
+
+		s = &hubSource{
+			hub:        h,
+			uri:        uri,
+			supervisor: NewSupervisor(newClient(uri)),
+			ready:      make(chan struct{}),
+			done:       make(chan struct{}),
+		}
+		h.sources[uri] = s
+
+		go s.run()
+	}
+
+	if s.linger != nil {
+		s.linger.Stop()
+		s.linger = nil
+	}
+	s.refs++
+
+	return s
+}
+
+// release decrements consumers and stops source after linger delay
+func (h *Hub) release(s *hubSource) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s.refs--; s.refs > 0 || s.stopped {
+		return
+	}
+
+	linger := h.Linger
+	if linger <= 0 {
+		linger = 5 * time.Second
+	}
+
+	s.linger = time.AfterFunc(linger, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if s.refs == 0 {
+			h.stop(s)
+		}
+	})
+}
+
+// stop closes upstream session, should be called under h.mu
+func (h *Hub) stop(s *hubSource) {
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+
+	if h.sources[s.uri] == s {
+		delete(h.sources, s.uri)
+	}
+
+	close(s.done)
+	s.supervisor.Stop()
+}
+
+// Close stops all sources
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.sources {
+		h.stop(s)
+	}
+}
+
+// Stats returns snapshot of all upstream sessions, sorted by URL
+func (h *Hub) Stats() []ClientStats {
+	h.mu.Lock()
+	stats := make([]ClientStats, 0, len(h.sources))
+	for _, s := range h.sources {
+		stats = append(stats, s.supervisor.Client.Stats())
+	}
+	h.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].URL < stats[j].URL })
+	return stats
+}
+
+type hubSource struct {
+	hub        *Hub
+	uri        string
+	supervisor *Supervisor
+
+	ready  chan struct{} // closed on first PLAY or first error
+	err    error
+	tracks []*hubTrack
+
+	done chan struct{}
+
+	// guarded by hub.mu
+	refs    int
+	linger  *time.Timer
+	stopped bool
+}
+
+func (s *hubSource) run() {
+	go func() {
+		_ = s.supervisor.Run()
+	}()
+
+	var ready bool
+
+	for {
+		select {
+		case <-s.done:
+			if !ready {
+				s.err = errHubSource
+				close(s.ready)
+			}
+			for _, track := range s.tracks {
+				track.receiver.RemoveHandler(track.handler)
+				track.close()
+			}
+			return
+
+		case event := <-s.supervisor.Events():
+			switch event.State {
+			case StatePlay:
+				if !ready {
+					s.addTracks()
+					ready = true
+					close(s.ready)
+				}
+
+			case StateNone:
+				if !ready {
+					// first session failed, next Subscribe starts new source
+					s.err = event.Err
+					if s.err == nil {
+						s.err = errHubSource
+					}
+					ready = true
+					close(s.ready)
+
+					s.hub.mu.Lock()
+					s.hub.stop(s)
+					s.hub.mu.Unlock()
+				} else {
+					// keyframe from previous session is useless after reconnect
+					for _, track := range s.tracks {
+						track.resetCache()
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *hubSource) addTracks() {
+	c := s.supervisor.Client
+
+	c.receiversMu.RLock()
+	receivers := append([]*Receiver(nil), c.Receivers...)
+	c.receiversMu.RUnlock()
+
+	for _, receiver := range receivers {
+		track := &hubTrack{receiver: receiver}
+		track.cache = receiver.Codec != nil &&
+			(receiver.Codec.Name == CodecH264 || receiver.Codec.Name == CodecH265)
+		track.handler = &Handler{
+			OnCapturedRTP: func(packet *rtp.Packet, captureTime time.Time) {
+				track.onRTP(&capturedPacket{packet: packet, captureTime: captureTime})
+			},
+			Policy: Block,
+		}
+
+		receiver.AddHandler(track.handler)
+		s.tracks = append(s.tracks, track)
+	}
+}
+
+// hubTrack fans out packets of one upstream Receiver to consumers
+type hubTrack struct {
+	receiver *Receiver
+	handler  *Handler
+	cache    bool
+
+	mu        sync.Mutex
+	consumers []*Handler
+	gop       []*capturedPacket // packets from the last keyframe
+}
+
+func (t *hubTrack) onRTP(v *capturedPacket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cache {
+		if isKeyframe(t.receiver.Codec.Name, v.packet.Payload) &&
+			(len(t.gop) == 0 || t.gop[0].packet.Timestamp != v.packet.Timestamp) {
+			t.gop = append(t.gop[:0], v)
+		} else if len(t.gop) > 0 {
+			if len(t.gop) < MaxGOPPackets {
+				t.gop = append(t.gop, v)
+			} else {
+				t.gop = t.gop[:0]
+			}
+		}
+	}
+
+	for _, h := range t.consumers {
+		h.push(v)
+	}
+}
+
+func (t *hubTrack) addConsumer(h *Handler) {
+	// packets are pushed to all consumers under lock
+	if h.Policy == Block {
+		h.Policy = DropNewest
+	}
+
+	t.mu.Lock()
+	// cached GOP fits the queue, so it isn't cut and push doesn't block the track
+	h.start(len(t.gop))
+	for _, v := range t.gop {
+		h.push(v)
+	}
+	t.consumers = append(t.consumers, h)
+	t.mu.Unlock()
+}
+
+func (t *hubTrack) removeConsumer(h *Handler) {
+	h.detach(&t.mu, func() {
+		t.consumers = removeHandler(t.consumers, h)
+	})
+}
+
+// close removes all consumers, so they get Done
+func (t *hubTrack) close() {
+	t.mu.Lock()
+	consumers := append([]*Handler(nil), t.consumers...)
+	t.mu.Unlock()
+
+	for _, h := range consumers {
+		t.removeConsumer(h)
+	}
+}
+
+func (t *hubTrack) resetCache() {
+	t.mu.Lock()
+	t.gop = t.gop[:0]
+	t.mu.Unlock()
+}
+
+// isKeyframe checks if RTP payload has H264 or H265 parameter sets or IDR,
+// which start decodable frame
+func isKeyframe(codec string, payload []byte) bool {
+	var keyframe bool
+
+	switch codec {
+	case CodecH264:
+		if len(payload) < 2 {
+			return false
+		}
+		h264 := func(nalu []byte) {
+			switch nalu[0] & 0x1F {
+			case 5, 7:
+				keyframe = true
+			}
+		}
+		switch payload[0] & 0x1F {
+		case 24: // STAP-A
+			forEachNALU(payload[1:], h264)
+		case 28: // FU-A
+			return payload[1]&0x80 != 0 && payload[1]&0x1F == 5
+		default:
+			h264(payload)
+		}
+	case CodecH265:
+		if len(payload) < 3 {
+			return false
+		}
+		h265 := func(nalu []byte) {
+			switch typ := nalu[0] >> 1 & 0x3F; {
+			case typ >= 16 && typ <= 21, typ == 32, typ == 33:
+				keyframe = true
+			}
+		}
+		switch payload[0] >> 1 & 0x3F {
+		case 48: // AP
+			forEachNALU(payload[2:], h265)
+		case 49: // FU
+			typ := payload[2] & 0x3F
+			return payload[2]&0x80 != 0 && typ >= 16 && typ <= 21
+		default:
+			h265(payload)
+		}
+	}
+
+	return keyframe
+}
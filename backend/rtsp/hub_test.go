@@ -0,0 +1,232 @@
+package rtsp
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func TestHub(t *testing.T) {
+	srv, address := startTestServer(t)
+	st := addTestStream(srv)
+
+	var stop atomic.Bool
+	defer stop.Store(true)
+
+	go func() {
+		var seq uint16
+		write := func(ts uint32, payload ...byte) {
+			seq++
+			st.Receivers[0].WriteRTP(&rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: seq, Timestamp: ts, Marker: true},
+				Payload: payload,
+			})
+		}
+
+		for frame := 0; !stop.Load(); frame++ {
+			ts := uint32(frame * 3600)
+			if frame%10 == 0 {
+				write(ts, 0x67, 1, 2, 3)
+				write(ts, 0x65, 1, 2, 3)
+			} else {
+				write(ts, 0x41, 1, 2, 3)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var clients atomic.Int32
+
+	hub := NewHub()
+	hub.Linger = 100 * time.Millisecond
+	hub.NewClient = func(uri string) *Client {
+		clients.Add(1)
+		return NewClient(uri)
+	}
+	defer hub.Close()
+
+	uri := "rtsp://" + address + "/camera1"
+
+	subscribe := func() (*Subscription, chan *rtp.Packet) {
+		received := make(chan *rtp.Packet, 100)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		sub, err := hub.Subscribe(ctx, uri, func(r *Receiver) *Handler {
+			return &Handler{OnRTP: func(packet *rtp.Packet) { received <- packet }}
+		})
+		require.NoError(t, err)
+		require.Len(t, sub.Receivers(), 1)
+
+		return sub, received
+	}
+
+	sub1, received1 := subscribe()
+
+	// wait for a few keyframes, so cache is filled
+	for keyframes := 0; keyframes < 2; {
+		select {
+		case packet := <-received1:
+			if packet.Payload[0] == 0x65 {
+				keyframes++
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("no packets")
+		}
+	}
+
+	sub2, received2 := subscribe()
+
+	select {
+	case packet := <-received2:
+		require.True(t, isKeyframe(CodecH264, packet.Payload))
+	case <-time.After(3 * time.Second):
+		t.Fatal("no packets")
+	}
+
+	require.Equal(t, int32(1), clients.Load())
+
+	sub1.Close()
+	sub2.Close()
+
+	// source is closed after linger delay
+	require.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return len(hub.sources) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestHubSubscribeError(t *testing.T) {
+	hub := NewHub()
+	defer hub.Close()
+
+	// nobody listens on this port
+	_, err := hub.Subscribe(context.Background(), "rtsp://127.0.0.1:1/stream", func(r *Receiver) *Handler {
+		return &Handler{}
+	})
+	require.Error(t, err)
+
+	hub.mu.Lock()
+	require.Empty(t, hub.sources)
+	hub.mu.Unlock()
+}
+
+func TestHubTrackLongGOP(t *testing.T) {
+	for _, policy := range []DropPolicy{DropNewest, Block} {
+		track := &hubTrack{
+			receiver: NewReceiver(&Media{
+				Kind:   KindVideo,
+				Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+			}, 0),
+			cache: true,
+		}
+
+		write := func(ts uint32, payload ...byte) {
+			track.onRTP(&capturedPacket{packet: &rtp.Packet{Header: rtp.Header{Timestamp: ts}, Payload: payload}})
+		}
+
+		write(0, 0x65, 1)
+		for i := 1; i < 1000; i++ {
+			write(uint32(i), 0x41, 1)
+		}
+
+		// slow consumer with small queue
+		release := make(chan struct{})
+		var received atomic.Int32
+		h := &Handler{
+			OnRTP: func(packet *rtp.Packet) {
+				<-release
+				received.Add(1)
+			},
+			Policy:    policy,
+			QueueSize: 16,
+		}
+		track.addConsumer(h)
+
+		// other consumers are not blocked
+		write(1000, 0x41, 1)
+
+		close(release)
+		track.removeConsumer(h)
+		<-h.Done()
+
+		require.Equal(t, int32(1001), received.Load())
+		require.Zero(t, h.Dropped())
+	}
+}
+
+func TestHubTrackStalledConsumer(t *testing.T) {
+	track := &hubTrack{
+		receiver: NewReceiver(&Media{
+			Kind:   KindAudio,
+			Codecs: []*Codec{{Name: CodecPCMA, ClockRate: 8000, PayloadType: 8}},
+		}, 0),
+	}
+
+	release := make(chan struct{})
+	stalled := &Handler{
+		OnRTP: func(packet *rtp.Packet) {
+			<-release
+		},
+		Policy:    Block,
+		QueueSize: 4,
+	}
+	track.addConsumer(stalled)
+	defer close(release)
+
+	var received atomic.Int32
+	healthy := &Handler{
+		OnRTP: func(packet *rtp.Packet) {
+			received.Add(1)
+		},
+		Policy: Block,
+	}
+	track.addConsumer(healthy)
+
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			track.onRTP(&capturedPacket{packet: &rtp.Packet{Header: rtp.Header{Timestamp: uint32(i)}}})
+		}
+		close(written)
+	}()
+
+	select {
+	case <-written:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream is blocked by stalled consumer")
+	}
+
+	require.Eventually(t, func() bool {
+		return received.Load() == 100
+	}, 3*time.Second, 10*time.Millisecond)
+	require.NotZero(t, stalled.Dropped())
+}
+
+func TestIsKeyframe(t *testing.T) {
+	for _, test := range []struct {
+		Codec   string
+		Payload []byte
+		Result  bool
+	}{
+		{Codec: CodecH264, Payload: []byte{0x67, 0}, Result: true},
+		{Codec: CodecH264, Payload: []byte{0x65, 0}, Result: true},
+		{Codec: CodecH264, Payload: []byte{0x41, 0}},
+		{Codec: CodecH264, Payload: []byte{0x78, 0, 2, 0x67, 0, 0, 2, 0x68, 0}, Result: true}, // STAP-A
+		{Codec: CodecH264, Payload: []byte{0x7C, 0x85, 0}, Result: true},                      // FU-A start of IDR
+		{Codec: CodecH264, Payload: []byte{0x7C, 0x05, 0}},                                    // FU-A middle of IDR
+		{Codec: CodecH265, Payload: []byte{0x40, 0x01, 0}, Result: true},                      // VPS
+		{Codec: CodecH265, Payload: []byte{0x26, 0x01, 0}, Result: true},                      // IDR_W_RADL
+		{Codec: CodecH265, Payload: []byte{0x02, 0x01, 0}},                                    // TRAIL_R
+		{Codec: CodecH265, Payload: []byte{0x62, 0x01, 0x93, 0}, Result: true},                // FU start of IDR
+		{Codec: CodecOpus, Payload: []byte{0x65, 0}},
+	} {
+		require.Equal(t, test.Result, isKeyframe(test.Codec, test.Payload), "%s %x", test.Codec, test.Payload)
+	}
+}
@@ -2,6 +2,7 @@ package rtsp
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/vtpl1/phoring/backend/sdp"
@@ -13,6 +14,9 @@ type Media struct {
 	Codecs    []*Codec `json:"codecs,omitempty"`
 
 	ID string `json:"id,omitempty"` // MID for WebRTC, Control for RTSP
+
+	// Extensions - RTP header extensions from extmap, URI to ID
+	Extensions map[string]uint8 `json:"extensions,omitempty"`
 }
 
 func (m *Media) String() string {
@@ -81,6 +85,15 @@ func MarshalSDP(name string, medias []*Media) ([]byte, error) {
 			md.WithValueAttribute("control", media.ID)
 		}
 
+		uris := make([]string, 0, len(media.Extensions))
+		for uri := range media.Extensions {
+			uris = append(uris, uri)
+		}
+		sort.Slice(uris, func(i, j int) bool { return media.Extensions[uris[i]] < media.Extensions[uris[j]] })
+		for _, uri := range uris {
+			md.WithValueAttribute("extmap", fmt.Sprintf("%d %s", media.Extensions[uri], uri))
+		}
+
 		sd.MediaDescriptions = append(sd.MediaDescriptions, md)
 	}
 
@@ -98,6 +111,19 @@ func UnmarshalMedia(md *sdp.MediaDescription) *Media {
 			m.Direction = attr.Key
 		case "control", "mid":
 			m.ID = attr.Value
+		case "extmap":
+			// a=extmap:<id>[/<direction>] <uri> [<attributes>]
+			fields := strings.Fields(attr.Value)
+			if len(fields) < 2 {
+				continue
+			}
+			id, _, _ := strings.Cut(fields[0], "/")
+			if i := Atoi(id); i > 0 && i < 256 {
+				if m.Extensions == nil {
+					m.Extensions = map[string]uint8{}
+				}
+				m.Extensions[fields[1]] = uint8(i)
+			}
 		}
 	}
 
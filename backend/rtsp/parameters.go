@@ -0,0 +1,112 @@
+package rtsp
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const MimeTypeParameters = "text/parameters"
+
+// GetParameter requests values of named parameters, without names it works as
+// ping. Like other requests with response, it should be called before Handle.
+func (c *Client) GetParameter(names ...string) (map[string]string, error) {
+	req := &Request{Method: GET_PARAMETER, URL: c.URL}
+
+	if len(names) > 0 {
+		req.Header = map[string][]string{
+			"Content-Type": {MimeTypeParameters},
+		}
+		req.Body = []byte(strings.Join(names, EndLine) + EndLine)
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseParameters(res.Body), nil
+}
+
+// SetParameter sends parameters values to server
+func (c *Client) SetParameter(params map[string]string) error {
+	req := &Request{
+		Method: SET_PARAMETER,
+		URL:    c.URL,
+		Header: map[string][]string{
+			"Content-Type": {MimeTypeParameters},
+		},
+		Body: MarshalParameters(params),
+	}
+
+	_, err := c.Do(req)
+	return err
+}
+
+// ParseParameters parses text/parameters body `name: value` lines
+func ParseParameters(body []byte) map[string]string {
+	params := map[string]string{}
+
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		name, value, _ := bytes.Cut(line, []byte(":"))
+		params[string(bytes.TrimSpace(name))] = string(bytes.TrimSpace(value))
+	}
+
+	return params
+}
+
+// MarshalParameters returns text/parameters body sorted by name
+func MarshalParameters(params map[string]string) []byte {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b []byte
+	for _, name := range names {
+		b = append(b, name+": "+params[name]+EndLine...)
+	}
+	return b
+}
+
+// Supports returns true if method is in Public header of OPTIONS response
+func (c *Client) Supports(method string) bool {
+	return slices.Contains(c.public, method)
+}
+
+// readPublic saves methods of Public header, responses without it don't reset them
+func (c *Client) readPublic(res *Response) {
+	public := res.Header.Get("Public")
+	if public == "" {
+		return
+	}
+
+	c.public = c.public[:0]
+	for _, method := range strings.Split(public, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			c.public = append(c.public, method)
+		}
+	}
+}
+
+// keepaliveMethod returns KeepaliveMethod, method from camera quirk or GET_PARAMETER
+// if server supports it, because some servers refresh session only on GET_PARAMETER
+func (c *Client) keepaliveMethod() string {
+	if c.KeepaliveMethod != "" {
+		return c.KeepaliveMethod
+	}
+	if c.quirk.KeepaliveMethod != "" {
+		return c.quirk.KeepaliveMethod
+	}
+	if c.Supports(GET_PARAMETER) {
+		return GET_PARAMETER
+	}
+	return OPTIONS
+}
@@ -0,0 +1,45 @@
+package rtsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParameters(t *testing.T) {
+	params := map[string]string{"position": "12.5", "bitrate": "2048"}
+
+	body := MarshalParameters(params)
+	require.Equal(t, "bitrate: 2048\r\nposition: 12.5\r\n", string(body))
+	require.Equal(t, params, ParseParameters(body))
+
+	require.Equal(t, map[string]string{"a": "1", "b": ""}, ParseParameters([]byte("a:1\nb\r\n\r\n")))
+}
+
+func TestClientKeepaliveMethod(t *testing.T) {
+	srv, address := startTestServer(t)
+	addTestStream(srv)
+
+	client := NewClient("rtsp://" + address + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.Equal(t, OPTIONS, client.keepaliveMethod())
+
+	require.NoError(t, client.Options())
+	require.True(t, client.Supports(GET_PARAMETER))
+	require.Equal(t, GET_PARAMETER, client.keepaliveMethod())
+
+	// DESCRIBE response without Public header
+	require.NoError(t, client.Describe())
+	require.Equal(t, GET_PARAMETER, client.keepaliveMethod())
+
+	client.KeepaliveMethod = OPTIONS
+	require.Equal(t, OPTIONS, client.keepaliveMethod())
+
+	params, err := client.GetParameter()
+	require.NoError(t, err)
+	require.Empty(t, params)
+
+	require.NoError(t, client.SetParameter(map[string]string{"a": "1"}))
+}
@@ -0,0 +1,243 @@
+package rtsp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlayOptions - PLAY request headers for recordings playback, for example
+// ONVIF replay from NVR https://www.onvif.org/specs/stream/ONVIF-Streaming-Spec.pdf
+type PlayOptions struct {
+	// Range from NptRange or ClockRange, continue from current position if empty
+	Range string
+
+	// Scale - playback speed relative to real time, negative for reverse,
+	// server default if zero
+	Scale float64
+
+	// Speed - delivery speed relative to real time, server default if zero
+	Speed float64
+
+	// NoRateControl sends `Rate-Control: no`, so server delivers data as fast
+	// as possible and receiver should use RTP timestamps
+	NoRateControl bool
+
+	// Immediate sends `Immediate: yes`, so server breaks current PLAY
+	// and seeks without PAUSE
+	Immediate bool
+
+	// Replay sends `Require: onvif-replay`
+	Replay bool
+}
+
+// RTPInfo - one stream from RTP-Info header of PLAY response.
+// Packet with Seq has RTPTime timestamp and corresponds to start of Range.
+type RTPInfo struct {
+	URL        string
+	Seq        uint16
+	RTPTime    uint32
+	HasSeq     bool
+	HasRTPTime bool
+}
+
+const clockLayout = "20060102T150405.000Z"
+
+// NptRange returns Range value in normal play time, open end if end is zero
+func NptRange(start, end time.Duration) string {
+	s := "npt=" + strconv.FormatFloat(start.Seconds(), 'f', 3, 64) + "-"
+	if end > 0 {
+		s += strconv.FormatFloat(end.Seconds(), 'f', 3, 64)
+	}
+	return s
+}
+
+// ClockRange returns Range value in absolute UTC time, open end if end is zero
+func ClockRange(start, end time.Time) string {
+	s := "clock=" + start.UTC().Format(clockLayout) + "-"
+	if !end.IsZero() {
+		s += end.UTC().Format(clockLayout)
+	}
+	return s
+}
+
+// ParseRTPInfo parses `url=rtsp://cam/track1;seq=45102;rtptime=12345678,url=...`
+func ParseRTPInfo(s string) []RTPInfo {
+	var parts []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "url=") || len(parts) == 0 {
+			parts = append(parts, part)
+		} else {
+			// comma inside URL
+			parts[len(parts)-1] += "," + part
+		}
+	}
+
+	var infos []RTPInfo
+
+	for _, part := range parts {
+		var info RTPInfo
+		for _, param := range strings.Split(part, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch key {
+			case "url":
+				info.URL = value
+			case "seq":
+				if i, err := strconv.ParseUint(value, 10, 16); err == nil {
+					info.Seq = uint16(i)
+					info.HasSeq = true
+				}
+			case "rtptime":
+				if i, err := strconv.ParseUint(value, 10, 32); err == nil {
+					info.RTPTime = uint32(i)
+					info.HasRTPTime = true
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// PlayWith sends PLAY with Range, Scale and Speed. It can be used for seek
+// during Handle. Response is read by Handle, see Receiver.RTPInfo and PlayRange.
+func (c *Client) PlayWith(opts *PlayOptions) error {
+	req := &Request{Method: PLAY, URL: c.URL}
+
+	if opts != nil {
+		req.Header = map[string][]string{}
+		if opts.Range != "" {
+			req.Header.Set("Range", opts.Range)
+		}
+		if opts.Scale != 0 {
+			req.Header.Set("Scale", strconv.FormatFloat(opts.Scale, 'f', -1, 64))
+		}
+		if opts.Speed != 0 {
+			req.Header.Set("Speed", strconv.FormatFloat(opts.Speed, 'f', -1, 64))
+		}
+		if opts.NoRateControl {
+			req.Header.Set("Rate-Control", "no")
+		}
+		if opts.Immediate {
+			req.Header.Set("Immediate", "yes")
+		}
+		if opts.Replay {
+			req.Header.Set("Require", "onvif-replay")
+		}
+	}
+
+	if err := c.WriteRequest(req); err != nil {
+		return err
+	}
+
+	if c.paused.Swap(false) {
+		// don't count pause as UDP silence
+		c.lastUDP.Store(time.Now().UnixNano())
+	}
+	c.setState(StatePlay)
+	return nil
+}
+
+// Pause sends PAUSE, Handle keeps session alive until next PlayWith
+func (c *Client) Pause() error {
+	req := &Request{Method: PAUSE, URL: c.URL}
+	if err := c.WriteRequest(req); err != nil {
+		return err
+	}
+
+	c.paused.Store(true)
+	return nil
+}
+
+// PlayRange returns Range header of the last PLAY response
+func (c *Client) PlayRange() string {
+	if s, ok := c.playRange.Load().(string); ok {
+		return s
+	}
+	return ""
+}
+
+// handlePlayResponse checks response of pending PLAY and saves its Range and RTP-Info.
+// Responses on other requests, for example keepalive, are skipped.
+func (c *Client) handlePlayResponse(res *Response) error {
+	seq := c.playSeq.Load()
+	if seq == 0 {
+		return nil
+	}
+	// some cameras don't repeat CSeq
+	if cseq := res.Header.Get("CSeq"); cseq != "" && cseq != strconv.Itoa(int(seq)) {
+		return nil
+	}
+	c.playSeq.Store(0)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		c.setState(StateSetup)
+		return errors.New("rtsp: wrong response on PLAY: " + res.Status)
+	}
+
+	// for playing backchannel only after OK response on play
+	c.playOK.Store(true)
+
+	if s := res.Header.Get("Range"); s != "" {
+		c.playRange.Store(s)
+	}
+
+	s := res.Header.Get("RTP-Info")
+	if s == "" {
+		return nil
+	}
+
+	infos := ParseRTPInfo(s)
+
+	c.receiversMu.RLock()
+	defer c.receiversMu.RUnlock()
+
+	for i := range infos {
+		info := &infos[i]
+
+		for _, r := range c.Receivers {
+			single := len(c.Receivers) == 1 && len(infos) == 1
+			if single || r.Media.ID != "" && c.sameControl(info.URL, r.Media.ID) {
+				r.setRTPInfo(info)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameControl compares RTP-Info URL with media control, both are resolved
+// like SETUP URL. Credentials are ignored.
+func (c *Client) sameControl(rawURL, control string) bool {
+	u1, err := c.parseURL(c.controlURL(rawURL))
+	if err != nil {
+		return false
+	}
+	u2, err := c.parseURL(c.controlURL(control))
+	if err != nil {
+		return false
+	}
+	u1.User, u2.User = nil, nil
+	return u1.String() == u2.String()
+}
+
+// RTPInfo returns RTP-Info of the last PLAY response for this track
+func (r *Receiver) RTPInfo() (RTPInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.rtpInfo == nil {
+		return RTPInfo{}, false
+	}
+	return *r.rtpInfo, true
+}
+
+func (r *Receiver) setRTPInfo(info *RTPInfo) {
+	r.mu.Lock()
+	r.rtpInfo = info
+	r.mu.Unlock()
+}
@@ -0,0 +1,387 @@
+package rtsp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+// ProbeReport - facts about camera stream collected by Probe
+type ProbeReport struct {
+	URL      string         `json:"url"`
+	SDP      string         `json:"sdp,omitempty"`
+	Tracks   []*TrackReport `json:"tracks"`
+	Duration time.Duration  `json:"duration"` // from PLAY till end of probe
+}
+
+// TrackReport - facts about one track. Zero values mean unknown.
+type TrackReport struct {
+	Kind  string `json:"kind"`
+	Codec string `json:"codec"`
+
+	// video
+	Profile       string        `json:"profile,omitempty"`
+	Level         string        `json:"level,omitempty"`
+	Width         int           `json:"width,omitempty"`
+	Height        int           `json:"height,omitempty"`
+	FPS           float64       `json:"fps,omitempty"` // from SPS/VPS timing info or measured
+	GOP           int           `json:"gop,omitempty"` // frames between two keyframes
+	FirstKeyframe time.Duration `json:"first_keyframe,omitempty"`
+
+	// audio
+	SampleRate uint32 `json:"sample_rate,omitempty"`
+	Channels   uint16 `json:"channels,omitempty"`
+
+	Bitrate int `json:"bitrate,omitempty"` // bits per second
+	Packets int `json:"packets"`
+}
+
+// Probe connects to camera, plays all tracks and collects report during ProbeTimeout.
+// It returns earlier if keyframe interval of all video tracks is known.
+func Probe(uri string) (*ProbeReport, error) {
+	return ProbeContext(context.Background(), uri)
+}
+
+// ProbeContext is Probe which can be cancelled by ctx.
+// Partial report is returned with error if stream breaks after PLAY.
+func ProbeContext(ctx context.Context, uri string) (*ProbeReport, error) {
+	ctx, finish := context.WithCancelCause(ctx)
+	defer finish(nil)
+
+	ctx, cancel := context.WithTimeout(ctx, ProbeTimeout)
+	defer cancel()
+
+	client := NewClient(uri)
+	if err := client.DialContext(ctx); err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if err := client.DescribeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var probes []*trackProbe
+
+	for _, media := range client.Medias {
+		if media.Direction == DirectionSendonly {
+			continue // backchannel
+		}
+
+		channel, err := client.SetupMediaContext(ctx, media)
+		if err != nil {
+			return nil, err
+		}
+
+		probe := newTrackProbe(client.Receiver(channel), func() { finish(errProbeDone) })
+		probes = append(probes, probe)
+	}
+
+	if len(probes) == 0 {
+		return nil, errors.New("rtsp: no medias to probe")
+	}
+
+	start := time.Now()
+	for _, probe := range probes {
+		probe.others = probes
+		probe.start = start
+		probe.receiver.AddHandler(probe.handler)
+	}
+
+	err := client.PlayContext(ctx)
+	if err == nil {
+		err = client.HandleContext(ctx)
+	}
+
+	report := &ProbeReport{URL: uri, SDP: client.SDP, Duration: time.Since(start)}
+
+	for _, probe := range probes {
+		probe.receiver.RemoveHandler(probe.handler)
+		<-probe.handler.Done()
+
+		report.Tracks = append(report.Tracks, probe.report())
+	}
+
+	// timeout or all facts collected
+	if err != nil && ctx.Err() != nil {
+		if cause := context.Cause(ctx); cause == errProbeDone || cause == context.DeadlineExceeded {
+			err = nil
+		}
+	}
+
+	return report, err
+}
+
+var errProbeDone = errors.New("rtsp: probe done")
+
+type trackProbe struct {
+	receiver *Receiver
+	handler  *Handler
+	others   []*trackProbe
+	finish   func()
+	start    time.Time
+
+	mu sync.Mutex
+
+	sps *SPS
+	fps float64 // from VPS
+
+	packets    int
+	bytes      int // without last frame, so it matches firstTS-lastTS interval
+	frameBytes int
+	firstTS    uint32
+	lastTS     uint32
+	frames     int // video frames, counted by timestamp change
+	keyframe   int // index of frame with last keyframe, -1 if none
+	gop        int
+
+	firstKeyframe time.Duration
+}
+
+func newTrackProbe(receiver *Receiver, finish func()) *trackProbe {
+	p := &trackProbe{receiver: receiver, finish: finish, keyframe: -1}
+	p.handler = &Handler{OnRTP: p.onRTP, Policy: Block}
+
+	if receiver.Codec != nil {
+		p.sps = ParseSpropSPS(receiver.Codec)
+	}
+
+	return p
+}
+
+func (p *trackProbe) onRTP(packet *rtp.Packet) {
+	p.mu.Lock()
+
+	if p.packets == 0 {
+		p.firstTS = packet.Timestamp
+	} else if packet.Timestamp != p.lastTS {
+		p.frames++
+		p.bytes += p.frameBytes
+		p.frameBytes = 0
+	}
+
+	p.packets++
+	p.frameBytes += len(packet.Payload)
+	p.lastTS = packet.Timestamp
+
+	if p.receiver.Media.Kind == KindVideo && p.receiver.Codec != nil {
+		p.parseNALUs(packet.Payload)
+	}
+
+	done := p.gop > 0
+
+	p.mu.Unlock()
+
+	if done && p.allDone() {
+		p.finish()
+	}
+}
+
+// allDone checks that GOP known for all video tracks and other tracks have packets
+func (p *trackProbe) allDone() bool {
+	for _, probe := range p.others {
+		probe.mu.Lock()
+		done := probe.packets > 0 && (probe.receiver.Media.Kind != KindVideo || probe.gop > 0)
+		probe.mu.Unlock()
+		if !done {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *trackProbe) parseNALUs(payload []byte) {
+	switch p.receiver.Codec.Name {
+	case CodecH264:
+		if len(payload) < 2 {
+			return
+		}
+		switch payload[0] & 0x1F {
+		case 24: // STAP-A
+			forEachNALU(payload[1:], p.onH264NALU)
+		case 28: // FU-A
+			if payload[1]&0x80 != 0 {
+				p.onH264NALU([]byte{payload[1]&0x1F | payload[0]&0xE0})
+			}
+		default:
+			p.onH264NALU(payload)
+		}
+	case CodecH265:
+		if len(payload) < 3 {
+			return
+		}
+		switch payload[0] >> 1 & 0x3F {
+		case 48: // AP
+			forEachNALU(payload[2:], p.onH265NALU)
+		case 49: // FU
+			if payload[2]&0x80 != 0 {
+				p.onH265NALU([]byte{payload[2]&0x3F<<1 | payload[0]&0x81, payload[1]})
+			}
+		default:
+			p.onH265NALU(payload)
+		}
+	}
+}
+
+func (p *trackProbe) onH264NALU(nalu []byte) {
+	switch nalu[0] & 0x1F {
+	case 5: // IDR
+		p.onKeyframe()
+	case 7:
+		if sps, err := ParseH264SPS(nalu); err == nil {
+			p.sps = sps
+		}
+	}
+}
+
+func (p *trackProbe) onH265NALU(nalu []byte) {
+	switch typ := nalu[0] >> 1 & 0x3F; {
+	case typ >= 16 && typ <= 21: // IRAP
+		p.onKeyframe()
+	case typ == 32:
+		if fps, err := ParseH265VPS(nalu); err == nil && fps > 0 {
+			p.fps = fps
+		}
+	case typ == 33:
+		if sps, err := ParseH265SPS(nalu); err == nil {
+			p.sps = sps
+		}
+	}
+}
+
+func (p *trackProbe) onKeyframe() {
+	if p.keyframe == p.frames {
+		return // next packet of the same keyframe
+	}
+
+	if p.keyframe < 0 {
+		p.firstKeyframe = time.Since(p.start)
+	} else if p.gop == 0 {
+		p.gop = p.frames - p.keyframe
+	}
+
+	p.keyframe = p.frames
+}
+
+func (p *trackProbe) report() *TrackReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r := &TrackReport{
+		Kind:          p.receiver.Media.Kind,
+		Packets:       p.packets,
+		GOP:           p.gop,
+		FirstKeyframe: p.firstKeyframe,
+	}
+
+	codec := p.receiver.Codec
+	if codec == nil {
+		return r
+	}
+
+	r.Codec = codec.Name
+
+	// duration of received data by RTP clock
+	var duration float64
+	if codec.ClockRate > 0 {
+		duration = float64(p.lastTS-p.firstTS) / float64(codec.ClockRate)
+	}
+	if duration > 0 {
+		r.Bitrate = int(float64(p.bytes*8) / duration)
+	}
+
+	switch r.Kind {
+	case KindVideo:
+		if sps := p.sps; sps != nil {
+			r.Profile = sps.Profile
+			r.Level = sps.Level
+			r.Width = sps.Width
+			r.Height = sps.Height
+			r.FPS = sps.FPS
+		}
+		if r.FPS == 0 {
+			r.FPS = p.fps
+		}
+		if r.FPS == 0 && duration > 0 {
+			r.FPS = float64(p.frames) / duration
+		}
+	case KindAudio:
+		r.SampleRate = codec.ClockRate
+		r.Channels = codec.Channels
+		if codec.Name == CodecAAC {
+			if rate, channels := parseAACConfig(codec.FmtpLine); rate > 0 {
+				r.SampleRate, r.Channels = rate, channels
+			}
+		}
+		if r.Channels == 0 {
+			r.Channels = 1
+		}
+	}
+
+	return r
+}
+
+// forEachNALU iterates over aggregation units with 16-bit size prefix
+func forEachNALU(b []byte, fn func(nalu []byte)) {
+	for len(b) > 2 {
+		size := int(binary.BigEndian.Uint16(b))
+		if size == 0 || 2+size > len(b) {
+			return
+		}
+		fn(b[2 : 2+size])
+		b = b[2+size:]
+	}
+}
+
+var aacSampleRates = []uint32{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// parseAACConfig returns sample rate and channels from AudioSpecificConfig in fmtp line
+func parseAACConfig(fmtp string) (uint32, uint16) {
+	for _, param := range strings.Split(fmtp, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(key, "config") {
+			continue
+		}
+
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return 0, 0
+		}
+		return ParseAudioSpecificConfig(b)
+	}
+	return 0, 0
+}
+
+// ParseAudioSpecificConfig returns sample rate and channels of AAC
+// AudioSpecificConfig, zero rate for broken config
+func ParseAudioSpecificConfig(b []byte) (uint32, uint16) {
+	if len(b) < 2 {
+		return 0, 0
+	}
+
+	r := &bitReader{buf: b}
+	if r.readBits(5) == 31 { // audioObjectType
+		r.readBits(6)
+	}
+
+	var rate uint32
+	if i := r.readBits(4); i == 15 {
+		rate = r.readBits(24)
+	} else if int(i) < len(aacSampleRates) {
+		rate = aacSampleRates[i]
+	}
+	channels := uint16(r.readBits(4))
+
+	if r.err != nil {
+		return 0, 0
+	}
+	return rate, channels
+}
@@ -0,0 +1,81 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func TestProbe(t *testing.T) {
+	srv, address := startTestServer(t)
+	st := srv.AddStream("camera1",
+		&Media{
+			Kind: KindVideo, Direction: DirectionRecvonly,
+			Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+		},
+		&Media{
+			Kind: KindAudio, Direction: DirectionRecvonly,
+			Codecs: []*Codec{{
+				Name: CodecAAC, ClockRate: 16000, PayloadType: 97,
+				FmtpLine: "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1210",
+			}},
+		},
+	)
+
+	sps, err := base64.StdEncoding.DecodeString("Z2QAH6wrQCgC3QDxImo=")
+	require.NoError(t, err)
+
+	var stop atomic.Bool
+	defer stop.Store(true)
+
+	go func() {
+		var seq uint16
+		write := func(i int, ts uint32, payload []byte) {
+			seq++
+			st.Receivers[i].WriteRTP(&rtp.Packet{
+				Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: ts, Marker: true},
+				Payload: payload,
+			})
+		}
+
+		// 25 fps, keyframe every 10 frames, 1000 bytes per frame
+		for frame := 0; !stop.Load(); frame++ {
+			ts := uint32(frame * 3600)
+			if frame%10 == 0 {
+				write(0, ts, sps)
+				write(0, ts, append([]byte{0x65}, make([]byte, 999)...))
+			} else {
+				write(0, ts, append([]byte{0x41}, make([]byte, 999)...))
+			}
+			write(1, uint32(frame*640), make([]byte, 100))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	report, err := Probe("rtsp://" + address + "/camera1")
+	require.NoError(t, err)
+	require.Len(t, report.Tracks, 2)
+	require.Less(t, report.Duration, ProbeTimeout)
+
+	video := report.Tracks[0]
+	require.Equal(t, KindVideo, video.Kind)
+	require.Equal(t, CodecH264, video.Codec)
+	require.Equal(t, "High", video.Profile)
+	require.Equal(t, "3.1", video.Level)
+	require.Equal(t, 1280, video.Width)
+	require.Equal(t, 720, video.Height)
+	require.Equal(t, 10, video.GOP)
+	require.InDelta(t, 25, video.FPS, 0.1)
+	require.InDelta(t, 200_000, video.Bitrate, 10_000)
+	require.Greater(t, video.FirstKeyframe, time.Duration(0))
+
+	audio := report.Tracks[1]
+	require.Equal(t, KindAudio, audio.Kind)
+	require.Equal(t, uint32(44100), audio.SampleRate)
+	require.Equal(t, uint16(2), audio.Channels)
+	require.Greater(t, audio.Packets, 0)
+}
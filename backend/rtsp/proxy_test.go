@@ -0,0 +1,172 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startTestProxy serves each connection with handshake, which returns target address
+func startTestProxy(t *testing.T, handshake func(conn net.Conn, r *bufio.Reader) string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				address := handshake(conn, r)
+				if address == "" {
+					return
+				}
+
+				target, err := net.Dial("tcp", address)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+
+				go func() {
+					_, _ = io.Copy(target, r)
+					_ = target.Close()
+				}()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func socks5Handshake(conn net.Conn, r *bufio.Reader) string {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return ""
+	}
+	if _, err := io.ReadFull(r, make([]byte, b[1])); err != nil {
+		return ""
+	}
+	_, _ = conn.Write([]byte{0x05, 0x02}) // username/password
+
+	// 0x01, user length, user, pass length, pass
+	if _, err := io.ReadFull(r, b); err != nil {
+		return ""
+	}
+	user := make([]byte, b[1])
+	_, _ = io.ReadFull(r, user)
+	n, _ := r.ReadByte()
+	pass := make([]byte, n)
+	_, _ = io.ReadFull(r, pass)
+
+	if string(user) != "admin" || string(pass) != "secret" {
+		_, _ = conn.Write([]byte{0x01, 0x01})
+		return ""
+	}
+	_, _ = conn.Write([]byte{0x01, 0x00})
+
+	b = make([]byte, 4)
+	if _, err := io.ReadFull(r, b); err != nil || b[3] != 0x01 {
+		return ""
+	}
+	b = make([]byte, 6)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return ""
+	}
+	_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	ip := net.IP(b[:4])
+	port := binary.BigEndian.Uint16(b[4:])
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+func connectHandshake(conn net.Conn, r *bufio.Reader) string {
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Method != http.MethodConnect {
+		return ""
+	}
+	if proxyAuth := req.Header.Get("Proxy-Authorization"); proxyAuth != "Basic YWRtaW46c2VjcmV0" {
+		_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		return ""
+	}
+	_, _ = conn.Write([]byte("HTTP/1.0 200 Connection established\r\n\r\n"))
+	return req.Host
+}
+
+func TestClientProxy(t *testing.T) {
+	srv, address := startTestServer(t)
+	addTestStream(srv)
+
+	socks5 := startTestProxy(t, socks5Handshake)
+	connect := startTestProxy(t, connectHandshake)
+
+	for _, test := range []struct {
+		Proxy string
+		Error bool
+	}{
+		{Proxy: "socks5://admin:secret@" + socks5},
+		{Proxy: "socks5://admin:wrong@" + socks5, Error: true},
+		{Proxy: "http://admin:secret@" + connect},
+		{Proxy: "http://" + connect, Error: true},
+		{Proxy: "ftp://" + connect, Error: true},
+	} {
+		client := NewClient("rtsp://" + address + "/camera1")
+		client.Proxy = test.Proxy
+
+		err := client.Dial()
+		if test.Error {
+			require.Error(t, err, test.Proxy)
+			continue
+		}
+		require.NoError(t, err, test.Proxy)
+
+		require.NoError(t, client.Describe())
+		require.Len(t, client.Medias, 1)
+		_ = client.Close()
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("RTSP_PROXY", "")
+	t.Setenv("ALL_PROXY", "socks5h://127.0.0.1:1080")
+	t.Setenv("NO_PROXY", "localhost,.lan,10.0.0.0/8")
+
+	for _, test := range []struct {
+		Host  string
+		Proxy string
+	}{
+		{Host: "camera.example.com", Proxy: "socks5h://127.0.0.1:1080"},
+		{Host: "localhost"},
+		{Host: "camera.lan"},
+		{Host: "10.1.2.3"},
+		{Host: "192.168.1.10", Proxy: "socks5h://127.0.0.1:1080"},
+	} {
+		proxy, err := ProxyFromEnvironment(test.Host)
+		require.NoError(t, err)
+		if test.Proxy == "" {
+			require.Nil(t, proxy, test.Host)
+		} else {
+			require.Equal(t, test.Proxy, proxy.String(), test.Host)
+		}
+	}
+
+	t.Setenv("RTSP_PROXY", "http://127.0.0.1:3128")
+	proxy, err := ProxyFromEnvironment("camera.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "http://127.0.0.1:3128", proxy.String())
+}
@@ -0,0 +1,92 @@
+package rtsp
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Publish pushes medias to remote RTSP server (MediaMTX, Wowza) with ANNOUNCE,
+// SETUP mode=record and RECORD. Returns Sender for each media in the same order.
+// Codecs without PayloadType get static type (PCMU, PCMA...) or free dynamic type.
+// Run Handle after Publish, so server keepalives and responses are processed.
+func (c *Client) Publish(medias ...*Media) ([]*Sender, error) {
+	c.Medias = nil
+	for i, media := range medias {
+		clone := *media
+		if clone.ID == "" {
+			clone.ID = "streamid=" + strconv.Itoa(i)
+		}
+		clone.Codecs = make([]*Codec, len(media.Codecs))
+		for j, codec := range media.Codecs {
+			codecClone := *codec
+			clone.Codecs[j] = &codecClone
+		}
+		c.Medias = append(c.Medias, &clone)
+	}
+
+	if err := assignPayloadTypes(c.Medias); err != nil {
+		return nil, err
+	}
+
+	c.mode = ModeActiveConsumer
+
+	if err := c.Announce(); err != nil {
+		return nil, err
+	}
+
+	senders := make([]*Sender, 0, len(c.Medias))
+	for _, media := range c.Medias {
+		channel, err := c.SetupMedia(media)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, c.Sender(channel))
+	}
+
+	if err := c.Record(); err != nil {
+		return nil, err
+	}
+
+	c.setState(StatePlay)
+
+	// RECORD acknowledged, senders can write
+	c.playOK.Store(true)
+
+	return senders, nil
+}
+
+// assignPayloadTypes sets types of codecs with zero PayloadType, because
+// zero is static type of PCMU
+func assignPayloadTypes(medias []*Media) error {
+	used := map[uint8]bool{}
+	for _, media := range medias {
+		for _, codec := range media.Codecs {
+			used[codec.PayloadType] = true
+		}
+	}
+
+	next := uint8(96)
+
+	for _, media := range medias {
+		for _, codec := range media.Codecs {
+			if codec.PayloadType != 0 {
+				continue
+			}
+			if pt, ok := staticPayloadType(codec); ok {
+				codec.PayloadType = pt
+				continue
+			}
+
+			for used[next] && next < 127 {
+				next++
+			}
+			if used[next] {
+				return errors.New("rtsp: no free dynamic payload type")
+			}
+			codec.PayloadType = next
+			used[next] = true
+		}
+	}
+
+	return nil
+}
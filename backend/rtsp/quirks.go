@@ -0,0 +1,256 @@
+package rtsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/vtpl1/phoring/backend/sdp"
+)
+
+const (
+	AuthStyleTPLink = "tplink" // request without credentials with 127.0.0.1 host
+
+	InterleavedRequest = "request" // requested channel if answer has no interleaved
+	InterleavedIgnore  = "ignore"  // always requested channel, answer is wrong
+)
+
+// Quirk - workaround for buggy cameras. Quirk is applied when all its non-empty
+// match fields are substrings of camera values, quirk without match fields is
+// applied to any camera. Quirks can be loaded from config with ParseQuirks.
+type Quirk struct {
+	Name string `json:"name"`
+
+	Server    string `json:"server,omitempty"`     // Server header of response
+	Origin    string `json:"origin,omitempty"`     // username from SDP o= line
+	UserAgent string `json:"user_agent,omitempty"` // Client.UserAgent
+	Challenge string `json:"challenge,omitempty"`  // WWW-Authenticate header
+
+	KeepaliveMethod string `json:"keepalive_method,omitempty"`
+	AuthStyle       string `json:"auth_style,omitempty"`
+	Interleaved     string `json:"interleaved,omitempty"`
+
+	// SDP and URL repairs
+	Direction    string `json:"direction,omitempty"`     // direction for all medias
+	DoubleScheme bool   `json:"double_scheme,omitempty"` // rtsp://rtsp:// in Content-Base and control
+	PCMBandwidth bool   `json:"pcm_bandwidth,omitempty"` // guess PCM codec by b= for dynamic payload without rtpmap
+
+	// BackchannelRetry - reconnect without backchannel if SETUP fails
+	BackchannelRetry bool `json:"backchannel_retry,omitempty"`
+}
+
+// DefaultQuirks - used by Client if Quirks is nil. Quirks without match fields
+// have no reliable signature and are harmless for other cameras.
+var DefaultQuirks = []*Quirk{
+	{
+		// https://drmnsamoliu.github.io/video.html
+		Name: "tp-link", Challenge: "TP-LINK Streaming Media", AuthStyle: AuthStyleTPLink,
+	},
+	{
+		// https://github.com/AlexxIT/go2rtc/issues/771
+		Name: "cv-rtsphandler", Origin: "CV-RTSPHandler", Direction: DirectionRecvonly,
+	},
+	{
+		// Escam Q6: Transport: RTP/AVP;unicast;destination=192.168.1.111;source=192.168.1.222
+		Name: "escam", Interleaved: InterleavedRequest,
+	},
+	{
+		// Content-Base: rtsp://rtsp://turret2-cam.lan:554/stream1/
+		// https://github.com/AlexxIT/go2rtc/issues/830
+		Name: "double-scheme", DoubleScheme: true,
+	},
+	{
+		// some Dahua/Amcrest cameras fail SETUP because two simultaneous
+		// backchannel connections
+		Name: "dahua", BackchannelRetry: true,
+	},
+	{
+		// FFmpeg + RTSP + pcm_s16le = doesn't pass info about codec name and params
+		// https://github.com/AlexxIT/go2rtc/issues/523
+		Name: "ffmpeg", PCMBandwidth: true,
+	},
+}
+
+// ParseQuirks parses and validates JSON array of quirks
+func ParseQuirks(b []byte) ([]*Quirk, error) {
+	var quirks []*Quirk
+	if err := json.Unmarshal(b, &quirks); err != nil {
+		return nil, err
+	}
+
+	for _, q := range quirks {
+		if err := q.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return quirks, nil
+}
+
+func (q *Quirk) validate() error {
+	switch q.KeepaliveMethod {
+	case "", OPTIONS, GET_PARAMETER, SET_PARAMETER:
+	default:
+		return fmt.Errorf("rtsp: quirk %s: wrong keepalive method: %s", q.Name, q.KeepaliveMethod)
+	}
+
+	switch q.AuthStyle {
+	case "", AuthStyleTPLink:
+	default:
+		return fmt.Errorf("rtsp: quirk %s: wrong auth style: %s", q.Name, q.AuthStyle)
+	}
+
+	switch q.Interleaved {
+	case "", InterleavedRequest, InterleavedIgnore:
+	default:
+		return fmt.Errorf("rtsp: quirk %s: wrong interleaved: %s", q.Name, q.Interleaved)
+	}
+
+	switch q.Direction {
+	case "", DirectionRecvonly, DirectionSendonly, DirectionSendRecv:
+	default:
+		return fmt.Errorf("rtsp: quirk %s: wrong direction: %s", q.Name, q.Direction)
+	}
+
+	return nil
+}
+
+// cameraInfo - camera values known so far, for quirks matching
+type cameraInfo struct {
+	server    string
+	origin    string
+	userAgent string
+	challenge string
+}
+
+func (q *Quirk) match(info *cameraInfo) bool {
+	return matchValue(q.Server, info.server) &&
+		matchValue(q.Origin, info.origin) &&
+		matchValue(q.UserAgent, info.userAgent) &&
+		matchValue(q.Challenge, info.challenge)
+}
+
+func matchValue(pattern, value string) bool {
+	return pattern == "" || (value != "" && strings.Contains(value, pattern))
+}
+
+// matchQuirks merges all matched quirks, first non-empty value wins
+func matchQuirks(quirks []*Quirk, info *cameraInfo) Quirk {
+	var merged Quirk
+
+	for _, q := range quirks {
+		if !q.match(info) {
+			continue
+		}
+
+		if merged.Name == "" {
+			merged.Name = q.Name
+		} else {
+			merged.Name += "," + q.Name
+		}
+		if merged.KeepaliveMethod == "" {
+			merged.KeepaliveMethod = q.KeepaliveMethod
+		}
+		if merged.AuthStyle == "" {
+			merged.AuthStyle = q.AuthStyle
+		}
+		if merged.Interleaved == "" {
+			merged.Interleaved = q.Interleaved
+		}
+		if merged.Direction == "" {
+			merged.Direction = q.Direction
+		}
+		merged.DoubleScheme = merged.DoubleScheme || q.DoubleScheme
+		merged.PCMBandwidth = merged.PCMBandwidth || q.PCMBandwidth
+		merged.BackchannelRetry = merged.BackchannelRetry || q.BackchannelRetry
+	}
+
+	return merged
+}
+
+// updateQuirks matches quirks with response headers and current camera info
+func (c *Client) updateQuirks(res *Response) {
+	if res != nil {
+		if s := res.Header.Get("Server"); s != "" {
+			c.camera.server = s
+		}
+		if s := res.Header.Get("WWW-Authenticate"); s != "" {
+			c.camera.challenge = s
+		}
+	}
+	c.camera.userAgent = c.UserAgent
+
+	quirks := c.Quirks
+	if quirks == nil {
+		quirks = DefaultQuirks
+	}
+	c.quirk = matchQuirks(quirks, &c.camera)
+}
+
+// ActiveQuirks returns names of quirks applied to camera, comma separated
+func (c *Client) ActiveQuirks() string {
+	return c.quirk.Name
+}
+
+// parseURL parses URL from camera answer
+func (c *Client) parseURL(rawURL string) (*url.URL, error) {
+	if c.quirk.DoubleScheme && strings.HasPrefix(rawURL, "rtsp://rtsp://") {
+		rawURL = rawURL[7:]
+	}
+	return urlParse(rawURL)
+}
+
+// repairMedias applies SDP repairs of quirk
+func (q *Quirk) repairMedias(sd *sdp.SessionDescription, medias []*Media) {
+	for i, media := range medias {
+		if q.Direction != "" {
+			media.Direction = q.Direction
+		}
+		if q.PCMBandwidth {
+			guessPCM(sd.MediaDescriptions[i], media)
+		}
+	}
+}
+
+// guessPCM guesses PCM format of dynamic payload without rtpmap by bitrate
+func guessPCM(md *sdp.MediaDescription, media *Media) {
+	if media.Kind != KindAudio || len(md.Bandwidth) == 0 {
+		return
+	}
+
+	for _, codec := range media.Codecs {
+		switch codec.Name {
+		case "96", "97", "98":
+		default:
+			continue
+		}
+
+		switch md.Bandwidth[0].Bandwidth {
+		case 128:
+			codec.ClockRate = 8000
+		case 256:
+			codec.ClockRate = 16000
+		case 384:
+			codec.ClockRate = 24000
+		case 512:
+			codec.ClockRate = 32000
+		case 705:
+			codec.ClockRate = 44100
+		case 768:
+			codec.ClockRate = 48000
+		case 1411:
+			// default Windows DShow
+			codec.ClockRate = 44100
+			codec.Channels = 2
+		case 1536:
+			// default Linux ALSA
+			codec.ClockRate = 48000
+			codec.Channels = 2
+		default:
+			continue
+		}
+
+		codec.Name = CodecPCML
+	}
+}
@@ -0,0 +1,120 @@
+package rtsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuirks(t *testing.T) {
+	quirks, err := ParseQuirks([]byte(`[{"name":"hikvision","server":"Hikvision","keepalive_method":"GET_PARAMETER"}]`))
+	require.NoError(t, err)
+	require.Equal(t, []*Quirk{{Name: "hikvision", Server: "Hikvision", KeepaliveMethod: GET_PARAMETER}}, quirks)
+
+	for _, raw := range []string{
+		`[{"name":"a","keepalive_method":"PLAY"}]`,
+		`[{"name":"a","auth_style":"bearer"}]`,
+		`[{"name":"a","interleaved":"random"}]`,
+		`[{"name":"a","direction":"inactive"}]`,
+		`{"name":"a"}`,
+	} {
+		_, err = ParseQuirks([]byte(raw))
+		require.Error(t, err, raw)
+	}
+}
+
+func TestMatchQuirks(t *testing.T) {
+	quirks := []*Quirk{
+		{Name: "a", Server: "Vendor A", KeepaliveMethod: SET_PARAMETER},
+		{Name: "b", Origin: "B", UserAgent: "player", KeepaliveMethod: OPTIONS, DoubleScheme: true},
+		{Name: "any", KeepaliveMethod: GET_PARAMETER, PCMBandwidth: true},
+	}
+
+	for _, test := range []struct {
+		Info      cameraInfo
+		Name      string
+		Keepalive string
+	}{
+		{Info: cameraInfo{}, Name: "any", Keepalive: GET_PARAMETER},
+		{Info: cameraInfo{server: "Vendor A/1.0"}, Name: "a,any", Keepalive: SET_PARAMETER},
+		{Info: cameraInfo{origin: "B"}, Name: "any", Keepalive: GET_PARAMETER},
+		{Info: cameraInfo{origin: "B", userAgent: "player/2"}, Name: "b,any", Keepalive: OPTIONS},
+	} {
+		quirk := matchQuirks(quirks, &test.Info)
+		require.Equal(t, test.Name, quirk.Name)
+		require.Equal(t, test.Keepalive, quirk.KeepaliveMethod)
+		require.True(t, quirk.PCMBandwidth)
+	}
+}
+
+func TestUnmarshalSDPQuirks(t *testing.T) {
+	// https://github.com/AlexxIT/go2rtc/issues/771
+	medias, err := UnmarshalSDP([]byte(`v=0
+o=CV-RTSPHandler 1123412 0 IN IP4 192.168.1.22
+s=Camera
+t=0 0
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+a=sendonly
+`))
+	require.NoError(t, err)
+	require.Equal(t, DirectionRecvonly, medias[0].Direction)
+
+	// https://github.com/AlexxIT/go2rtc/issues/523
+	medias, err = UnmarshalSDP([]byte(`v=0
+o=- 0 0 IN IP4 127.0.0.1
+s=No Name
+t=0 0
+m=audio 0 RTP/AVP 97
+b=AS:1411
+`))
+	require.NoError(t, err)
+	require.Equal(t, &Codec{Name: CodecPCML, ClockRate: 44100, Channels: 2, PayloadType: 97}, medias[0].Codecs[0])
+}
+
+func TestClientQuirks(t *testing.T) {
+	var address string
+	address = startFakeServer(t, func(c *Client, req *Request) bool {
+		res := NewResponse(req, OK)
+		res.Header.Set("Server", "Escam Q6")
+		switch req.Method {
+		case DESCRIBE:
+			res.Header.Set("Content-Type", "application/sdp")
+			res.Header.Set("Content-Base", "rtsp://rtsp://"+address+"/camera1/")
+			res.Body = []byte("v=0\r\no=- 0 0 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+				"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:trackID=0\r\n")
+		case SETUP:
+			res.Header.Set("Transport", "RTP/AVP;unicast;destination=127.0.0.1;source=127.0.0.1")
+			res.Header.Set("Session", "12345")
+		}
+		return c.WriteResponse(res) == nil
+	})
+
+	client := NewClient("rtsp://" + address + "/camera1")
+	client.Quirks = append([]*Quirk{
+		{Name: "escam-q6", Server: "Escam", KeepaliveMethod: SET_PARAMETER},
+	}, DefaultQuirks...)
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Describe())
+	require.Equal(t, address, client.URL.Host)
+	require.Contains(t, client.ActiveQuirks(), "escam-q6")
+	require.Equal(t, SET_PARAMETER, client.keepaliveMethod())
+
+	channel, err := client.SetupMedia(client.Medias[0])
+	require.NoError(t, err)
+	require.Equal(t, byte(0), channel)
+
+	// without quirks answer without interleaved is an error
+	client = NewClient("rtsp://" + address + "/camera1")
+	client.Quirks = []*Quirk{}
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Describe())
+	require.Equal(t, OPTIONS, client.keepaliveMethod())
+
+	_, err = client.SetupMedia(client.Medias[0])
+	require.Error(t, err)
+}
@@ -0,0 +1,255 @@
+package rtsp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtcp"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+// DropPolicy decides what happens with a packet when handler queue is full
+type DropPolicy byte
+
+const (
+	DropNewest DropPolicy = iota // skip incoming packet
+	DropOldest                   // remove the oldest queued packet
+	Block                        // wait for the handler, slows down the connection
+)
+
+const HandlerQueueSize = 256
+
+// Handler receives packets of one Receiver in its own goroutine,
+// so a slow consumer doesn't block the connection
+type Handler struct {
+	OnRTP  func(packet *rtp.Packet)
+	OnRTCP func(msg *RTCP)
+
+	// OnCapturedRTP - like OnRTP, with sender wall-clock time of packet from RTCP
+	// Sender Reports or abs-capture-time, zero until it is known. Called after OnRTP.
+	OnCapturedRTP func(packet *rtp.Packet, captureTime time.Time)
+
+	Policy    DropPolicy
+	QueueSize int // HandlerQueueSize if zero
+
+	queue   chan any
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+	handled atomic.Uint64
+}
+
+// Dropped returns number of packets lost because of full queue
+func (h *Handler) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+// Handled returns number of packets passed to callbacks
+func (h *Handler) Handled() uint64 {
+	return h.handled.Load()
+}
+
+// Done is closed when handler removed and all queued packets delivered
+func (h *Handler) Done() <-chan struct{} {
+	return h.done
+}
+
+// start runs delivery goroutine, queue gets extra room for packets pushed at once
+func (h *Handler) start(extra int) {
+	size := h.QueueSize
+	if size <= 0 {
+		size = HandlerQueueSize
+	}
+
+	h.queue = make(chan any, size+extra)
+	h.stop = make(chan struct{})
+	h.done = make(chan struct{})
+
+	go func() {
+		for v := range h.queue {
+			switch v := v.(type) {
+			case *capturedPacket:
+				if h.OnRTP != nil {
+					h.OnRTP(v.packet)
+				}
+				if h.OnCapturedRTP != nil {
+					h.OnCapturedRTP(v.packet, v.captureTime)
+				}
+			case *RTCP:
+				if h.OnRTCP != nil {
+					h.OnRTCP(v)
+				}
+			}
+			h.handled.Add(1)
+		}
+		close(h.done)
+	}()
+}
+
+func (h *Handler) push(v any) {
+	switch h.Policy {
+	case Block:
+		select {
+		case h.queue <- v:
+		case <-h.stop:
+		}
+	case DropOldest:
+		for {
+			select {
+			case h.queue <- v:
+				return
+			default:
+			}
+
+			select {
+			case <-h.queue:
+				h.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case h.queue <- v:
+		default:
+			h.dropped.Add(1)
+		}
+	}
+}
+
+// Receiver - incoming track of one Media. RTP comes on Channel and RTCP on Channel+1.
+// For UDP and multicast transports channel is virtual and equal to media position*2.
+type Receiver struct {
+	Media   *Media
+	Codec   *Codec
+	Channel byte
+
+	mu       sync.RWMutex
+	handlers []*Handler
+	rtpInfo  *RTPInfo
+
+	statsMu     sync.Mutex
+	sources     map[uint32]*rtpSource
+	earlySR     *rtcp.SenderReport // SR before first packet of its source
+	earlySRTime time.Time
+	statsStart  time.Time
+	traffic     trafficStats
+}
+
+func NewReceiver(media *Media, channel byte) *Receiver {
+	r := &Receiver{Media: media, Channel: channel}
+	if len(media.Codecs) > 0 {
+		r.Codec = media.Codecs[0]
+	}
+	return r
+}
+
+// AddHandler starts delivering packets to handler
+func (r *Receiver) AddHandler(h *Handler) {
+	h.start(0)
+
+	r.mu.Lock()
+	r.handlers = append(r.handlers, h)
+	r.mu.Unlock()
+}
+
+// RemoveHandler stops delivering packets to handler. Already queued packets
+// are still delivered, wait Handler.Done if needed. Safe to call from callback.
+func (r *Receiver) RemoveHandler(h *Handler) {
+	h.detach(&r.mu, func() {
+		r.handlers = removeHandler(r.handlers, h)
+	})
+}
+
+// detach unblocks pushers, calls remove under lock of pushers and closes queue
+func (h *Handler) detach(lock sync.Locker, remove func()) {
+	h.once.Do(func() {
+		close(h.stop)
+
+		lock.Lock()
+		remove()
+		lock.Unlock()
+
+		close(h.queue)
+	})
+}
+
+func removeHandler(handlers []*Handler, h *Handler) []*Handler {
+	for i, handler := range handlers {
+		if handler == h {
+			return append(handlers[:i], handlers[i+1:]...)
+		}
+	}
+	return handlers
+}
+
+// Close removes all handlers
+func (r *Receiver) Close() {
+	r.mu.RLock()
+	handlers := append([]*Handler(nil), r.handlers...)
+	r.mu.RUnlock()
+
+	for _, h := range handlers {
+		r.RemoveHandler(h)
+	}
+}
+
+// capturedPacket - RTP packet with capture time in handler queue
+type capturedPacket struct {
+	packet      *rtp.Packet
+	captureTime time.Time
+}
+
+// WriteRTP passes packet to all handlers
+func (r *Receiver) WriteRTP(packet *rtp.Packet) {
+	r.writeRTP(&capturedPacket{packet: packet})
+}
+
+func (r *Receiver) writeRTP(v *capturedPacket) {
+	r.mu.RLock()
+	for _, h := range r.handlers {
+		h.push(v)
+	}
+	r.mu.RUnlock()
+}
+
+// WriteRTCP passes packet to all handlers
+func (r *Receiver) WriteRTCP(msg *RTCP) {
+	r.mu.RLock()
+	for _, h := range r.handlers {
+		h.push(msg)
+	}
+	r.mu.RUnlock()
+}
+
+// Receiver returns track for RTP channel or nil
+func (c *Client) Receiver(channel byte) *Receiver {
+	c.receiversMu.RLock()
+	defer c.receiversMu.RUnlock()
+
+	for _, r := range c.Receivers {
+		if r.Channel == channel {
+			return r
+		}
+	}
+	return nil
+}
+
+// addReceiver creates track for media or updates channel of existing one
+// after reconnect, so handlers stay subscribed
+func (c *Client) addReceiver(media *Media, channel byte) *Receiver {
+	c.receiversMu.Lock()
+	defer c.receiversMu.Unlock()
+
+	for _, r := range c.Receivers {
+		if r.Media.Equal(media) {
+			r.Channel = channel
+			return r
+		}
+	}
+
+	r := NewReceiver(media, channel)
+	c.Receivers = append(c.Receivers, r)
+	return r
+}
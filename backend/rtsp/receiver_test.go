@@ -0,0 +1,87 @@
+package rtsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func TestReceiverHandler(t *testing.T) {
+	r := NewReceiver(&Media{Kind: KindVideo, Codecs: []*Codec{{Name: CodecH264}}}, 0)
+	require.Equal(t, CodecH264, r.Codec.Name)
+
+	var packets []uint16
+	var rtcpCount int
+
+	h := &Handler{
+		OnRTP:  func(packet *rtp.Packet) { packets = append(packets, packet.SequenceNumber) },
+		OnRTCP: func(msg *RTCP) { rtcpCount++ },
+		Policy: Block,
+	}
+	r.AddHandler(h)
+
+	for i := uint16(0); i < 10; i++ {
+		r.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: i}})
+	}
+	r.WriteRTCP(&RTCP{Channel: 1})
+
+	r.RemoveHandler(h)
+	<-h.Done()
+
+	require.Equal(t, []uint16{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, packets)
+	require.Equal(t, 1, rtcpCount)
+	require.Zero(t, h.Dropped())
+	require.Equal(t, uint64(11), h.Handled())
+
+	// packets after remove are ignored
+	r.WriteRTP(&rtp.Packet{})
+}
+
+func TestReceiverDropPolicy(t *testing.T) {
+	for _, test := range []struct {
+		Name   string
+		Policy DropPolicy
+		Want   []uint16
+	}{
+		{Name: "newest", Policy: DropNewest, Want: []uint16{0, 1}},
+		{Name: "oldest", Policy: DropOldest, Want: []uint16{3, 4}},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			r := NewReceiver(&Media{}, 0)
+
+			release := make(chan struct{})
+			started := make(chan struct{})
+
+			var packets []uint16
+			h := &Handler{
+				OnRTP: func(packet *rtp.Packet) {
+					if packet.SequenceNumber == 100 {
+						close(started)
+						<-release
+						return
+					}
+					packets = append(packets, packet.SequenceNumber)
+				},
+				Policy:    test.Policy,
+				QueueSize: 2,
+			}
+			r.AddHandler(h)
+
+			// block handler goroutine
+			r.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: 100}})
+			<-started
+
+			for i := uint16(0); i < 5; i++ {
+				r.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: i}})
+			}
+
+			close(release)
+			r.RemoveHandler(h)
+			<-h.Done()
+
+			require.Equal(t, test.Want, packets)
+			require.Equal(t, uint64(3), h.Dropped())
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package rtsp
+
+import (
+	"errors"
+	"net/url"
+)
+
+// MaxRedirects - limit of redirects in a row without successful response
+const MaxRedirects = 5
+
+var ErrTooManyRedirects = errors.New("rtsp: too many redirects")
+
+var ErrInsecureRedirect = errors.New("rtsp: redirect from TLS to plain connection")
+
+func isRedirect(code int) bool {
+	switch code {
+	case MovedPermanently, MovedTemporarily, SeeOther, 307:
+		return true
+	}
+	return false
+}
+
+// doRedirect re-dials Location of 3xx response and repeats request on new host.
+// Only requests to presentation URL (OPTIONS, DESCRIBE, ANNOUNCE) can be moved.
+func (c *Client) doRedirect(req *Request, res *Response) (*Response, error) {
+	location := res.Header.Get("Location")
+	if location == "" || req.URL.String() != c.URL.String() {
+		return res, errors.New("rtsp: unsupported redirect on " + req.Method)
+	}
+
+	if err := c.redial(location); err != nil {
+		return nil, err
+	}
+
+	req.URL = c.URL
+	req.Header.Del("Session")
+	req.Header.Del("Authorization")
+	return c.Do(req)
+}
+
+// handleRedirect moves current session to Location of REDIRECT request:
+// re-dials new host, setups the same medias and restores PLAY
+func (c *Client) handleRedirect(req *Request) error {
+	location := req.Header.Get("Location")
+	if location == "" {
+		return errors.New("rtsp: REDIRECT without Location")
+	}
+
+	if err := c.setLocation(location); err != nil {
+		return err
+	}
+
+	playing := c.State() == StatePlay
+
+	if err := c.Reconnect(); err != nil {
+		return err
+	}
+
+	if playing {
+		if err := c.Play(); err != nil {
+			return err
+		}
+	}
+
+	if c.hasUDP() {
+		c.startUDP()
+	}
+
+	return nil
+}
+
+// redial closes current connection and dials location
+func (c *Client) redial(location string) error {
+	if err := c.setLocation(location); err != nil {
+		return err
+	}
+
+	c.closeUDP()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+
+	return c.Dial()
+}
+
+// setLocation changes URL for next Dial. Location is resolved against dialed URI,
+// because Dial replaces rtsp+http and rtspx schemes. Credentials are carried over
+// to the same host or to any host with RedirectCredentials. TLS can't be downgraded.
+func (c *Client) setLocation(location string) error {
+	if c.redirects++; c.redirects > MaxRedirects {
+		return ErrTooManyRedirects
+	}
+
+	base, err := url.Parse(c.uri)
+	if err != nil {
+		return err
+	}
+
+	u, err := base.Parse(location)
+	if err != nil {
+		return err
+	}
+
+	if isSecureScheme(base.Scheme) && !isSecureScheme(u.Scheme) {
+		return ErrInsecureRedirect
+	}
+
+	if u.User == nil && c.auth != nil && c.auth.user != "" &&
+		(c.RedirectCredentials || u.Hostname() == base.Hostname()) {
+		u.User = url.UserPassword(c.auth.user, c.auth.pass)
+	}
+
+	c.uri = u.String()
+	return nil
+}
+
+func isSecureScheme(scheme string) bool {
+	return scheme == "rtsps" || scheme == "rtspx"
+}
@@ -0,0 +1,318 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtcp"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+// ReportInterval - minimal interval between RTCP receiver reports, RFC 3550 6.2
+const ReportInterval = 5 * time.Second
+
+const (
+	maxDropout  = 3000
+	maxMisorder = 100
+)
+
+// SourceStats - reception statistics of one RTP source, RFC 3550 A.3 and A.8
+type SourceStats struct {
+	SSRC     uint32 `json:"ssrc"`
+	Received uint32 `json:"received"`
+	Lost     int32  `json:"lost"`     // expected minus received, negative for duplicates
+	MaxSeq   uint32 `json:"maxSeq"`   // extended highest sequence number
+	Jitter   uint32 `json:"jitter"`   // in timestamp units
+	Restarts uint32 `json:"restarts"` // sequence jumps, ex. camera restart
+
+	Reordered uint32 `json:"reordered"` // duplicate or late packets
+
+	// Drift of RTP clock against sender wall clock from Sender Reports, ppm
+	Drift float64 `json:"drift,omitempty"`
+}
+
+// rtpSource - state of one SSRC for reception report
+type rtpSource struct {
+	ssrc      uint32
+	baseSeq   uint32
+	maxSeq    uint16
+	cycles    uint32
+	received  uint32
+	restarts  uint32
+	reordered uint32
+
+	expectedPrior uint32
+	receivedPrior uint32
+
+	transit    uint32
+	hasTransit bool
+	jitter     float64
+
+	lastSR     uint32 // middle 32 bits of NTP time from last SR
+	lastSRTime time.Time
+
+	clock wallClock
+}
+
+func newRTPSource(ssrc uint32, seq uint16) *rtpSource {
+	s := &rtpSource{ssrc: ssrc}
+	s.init(seq)
+	return s
+}
+
+func (s *rtpSource) init(seq uint16) {
+	s.baseSeq = uint32(seq)
+	s.maxSeq = seq
+	s.cycles = 0
+	s.received = 0
+	s.expectedPrior = 0
+	s.receivedPrior = 0
+	s.hasTransit = false
+}
+
+func (s *rtpSource) update(packet *rtp.Packet, arrival uint32) {
+	seq := packet.SequenceNumber
+
+	switch delta := seq - s.maxSeq; {
+	case delta == 0 && s.received > 0:
+		// duplicate of the last packet
+		s.reordered++
+	case delta < maxDropout:
+		// in order, with permissible gap
+		if seq < s.maxSeq {
+			s.cycles += 1 << 16
+		}
+		s.maxSeq = seq
+	case delta <= math.MaxUint16-maxMisorder:
+		// the sequence made a very large jump, assume that the other side restarted
+		s.init(seq)
+		s.restarts++
+	default:
+		// duplicate or reordered packet
+		s.reordered++
+	}
+
+	s.received++
+
+	// interarrival jitter, RFC 3550 A.8
+	transit := arrival - packet.Timestamp
+	if s.hasTransit {
+		d := float64(int32(transit - s.transit))
+		s.jitter += (math.Abs(d) - s.jitter) / 16
+	}
+	s.transit = transit
+	s.hasTransit = true
+}
+
+func (s *rtpSource) extendedMax() uint32 {
+	return s.cycles + uint32(s.maxSeq)
+}
+
+func (s *rtpSource) lost() int32 {
+	expected := s.extendedMax() - s.baseSeq + 1
+	return int32(expected - s.received)
+}
+
+func (s *rtpSource) stats() SourceStats {
+	return SourceStats{
+		SSRC:     s.ssrc,
+		Received: s.received,
+		Lost:     s.lost(),
+		MaxSeq:   s.extendedMax(),
+		Jitter:   uint32(s.jitter),
+		Restarts: s.restarts,
+
+		Reordered: s.reordered,
+		Drift:     s.clock.drift,
+	}
+}
+
+// report makes reception report block and starts next interval
+func (s *rtpSource) report(now time.Time) rtcp.ReceptionReport {
+	expected := s.extendedMax() - s.baseSeq + 1
+
+	expectedInterval := expected - s.expectedPrior
+	receivedInterval := s.received - s.receivedPrior
+	s.expectedPrior = expected
+	s.receivedPrior = s.received
+
+	var fraction uint8
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval > 0 && lostInterval > 0 {
+		fraction = uint8(lostInterval << 8 / int64(expectedInterval))
+	}
+
+	// cumulative number of packets lost is signed 24-bit value
+	lost := min(max(s.lost(), -0x800000), 0x7FFFFF)
+
+	rr := rtcp.ReceptionReport{
+		SSRC:               s.ssrc,
+		FractionLost:       fraction,
+		TotalLost:          uint32(lost) & 0xFFFFFF,
+		LastSequenceNumber: s.extendedMax(),
+		Jitter:             uint32(s.jitter),
+		LastSenderReport:   s.lastSR,
+	}
+
+	if s.lastSR != 0 {
+		rr.Delay = uint32(now.Sub(s.lastSRTime) * 65536 / time.Second)
+	}
+
+	return rr
+}
+
+// updateStats counts packet in reception statistics of its SSRC
+func (r *Receiver) updateStats(packet *rtp.Packet, now time.Time) (captureTime time.Time) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	if r.sources == nil {
+		r.sources = map[uint32]*rtpSource{}
+		r.statsStart = now
+	}
+
+	s := r.sources[packet.SSRC]
+	if s == nil {
+		s = newRTPSource(packet.SSRC, packet.SequenceNumber)
+		r.sources[packet.SSRC] = s
+
+		if sr := r.earlySR; sr != nil && sr.SSRC == packet.SSRC {
+			s.onSenderReport(sr, r.earlySRTime, r.clockRate())
+			r.earlySR = nil
+		}
+	}
+
+	// arrival time in timestamp units, only differences matter
+	arrival := uint32(int64(now.Sub(r.statsStart)) * int64(r.clockRate()) / int64(time.Second))
+
+	s.update(packet, arrival)
+	r.updateClock(s, packet)
+
+	var codec string
+	if r.Codec != nil {
+		codec = r.Codec.Name
+	}
+	r.traffic.update(packet, now, r.Media.Kind, codec)
+
+	captureTime, _ = s.clock.time(packet.Timestamp, r.clockRate())
+	return
+}
+
+// onSenderReport remembers time of last SR for LSR and DLSR fields of reports
+// and maps RTP time of source to wall clock. SR may come before first RTP packet,
+// so it is kept until source appears.
+func (r *Receiver) onSenderReport(sr *rtcp.SenderReport, now time.Time) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	if s := r.sources[sr.SSRC]; s != nil {
+		s.onSenderReport(sr, now, r.clockRate())
+	} else {
+		r.earlySR, r.earlySRTime = sr, now
+	}
+}
+
+func (s *rtpSource) onSenderReport(sr *rtcp.SenderReport, now time.Time, clockRate uint32) {
+	s.lastSR = uint32(sr.NTPTime >> 16)
+	s.lastSRTime = now
+	s.clock.onSenderReport(ntpTime(sr.NTPTime), sr.RTPTime, clockRate)
+}
+
+// Sources returns reception statistics for each SSRC of track, sorted by SSRC
+func (r *Receiver) Sources() []SourceStats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	return r.sourceStats()
+}
+
+func (r *Receiver) sourceStats() []SourceStats {
+	stats := make([]SourceStats, 0, len(r.sources))
+	for _, s := range r.sources {
+		stats = append(stats, s.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].SSRC < stats[j].SSRC })
+	return stats
+}
+
+func (r *Receiver) receptionReports(now time.Time) []rtcp.ReceptionReport {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	reports := make([]rtcp.ReceptionReport, 0, len(r.sources))
+	for _, s := range r.sources {
+		reports = append(reports, s.report(now))
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].SSRC < reports[j].SSRC })
+	return reports
+}
+
+// sendReceiverReports sends RR and SDES compound packet for each track
+func (c *Client) sendReceiverReports() error {
+	if c.ssrc == 0 {
+		c.ssrc = rand.Uint32()
+		c.cname = randomHex(8)
+	}
+
+	now := time.Now()
+
+	c.receiversMu.RLock()
+	receivers := append([]*Receiver(nil), c.Receivers...)
+	c.receiversMu.RUnlock()
+
+	for _, receiver := range receivers {
+		reports := receiver.receptionReports(now)
+		// RR can hold only 31 blocks
+		if len(reports) > 31 {
+			reports = reports[:31]
+		}
+
+		b, err := rtcp.Marshal([]rtcp.Packet{
+			&rtcp.ReceiverReport{SSRC: c.ssrc, Reports: reports},
+			rtcp.NewCNAMESourceDescription(c.ssrc, c.cname),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err = c.writeRTCP(receiver.Channel+1, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeRTCP sends packet to UDP socket of channel or as interleaved frame
+func (c *Client) writeRTCP(channel byte, b []byte) error {
+	for _, u := range c.udpConns() {
+		if u.channel+1 == channel {
+			if u.rtcpAddr == nil {
+				return nil
+			}
+			_, err := u.rtcp.WriteToUDP(b, u.rtcpAddr)
+			return err
+		}
+	}
+
+	// `$` + 1B channel number + 2B size
+	buf := make([]byte, 4, 4+len(b))
+	buf[0] = '$'
+	buf[1] = channel
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(b)))
+
+	return c.write(append(buf, b...))
+}
+
+// reportInterval returns randomized interval for next report, RFC 3550 6.3.1.
+// Session bandwidth is unknown, so minimal interval is used.
+func reportInterval(initial bool) time.Duration {
+	td := float64(ReportInterval)
+	if initial {
+		td /= 2
+	}
+	// compensation for "timer reconsideration" converging to a value below the intended average
+	return time.Duration(td * (rand.Float64() + 0.5) / (math.E - 1.5))
+}
@@ -0,0 +1,145 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtcp"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func TestRTPSource(t *testing.T) {
+	packet := func(seq uint16, ts uint32) *rtp.Packet {
+		return &rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: seq, Timestamp: ts}}
+	}
+
+	s := newRTPSource(1, 65530)
+
+	// wrap around, 65533 and 2 lost, 1 duplicated, 65535 reordered
+	for _, seq := range []uint16{65530, 65531, 65532, 65534, 0, 1, 1, 65535, 3} {
+		s.update(packet(seq, 0), 0)
+	}
+
+	stats := s.stats()
+	require.Equal(t, uint32(9), stats.Received)
+	require.Equal(t, int32(1), stats.Lost) // duplicate compensates one loss
+	require.Equal(t, uint32(1<<16+3), stats.MaxSeq)
+
+	rr := s.report(time.Now())
+	require.Equal(t, uint32(1<<16+3), rr.LastSequenceNumber)
+	require.Equal(t, uint8(256/10), rr.FractionLost)
+
+	// 10 packets expected, 5 received in next interval
+	for seq := uint16(5); seq < 14; seq += 2 {
+		s.update(packet(seq, 0), 0)
+	}
+	rr = s.report(time.Now())
+	require.Equal(t, uint8(5*256/10), rr.FractionLost)
+	require.Equal(t, uint32(6), rr.TotalLost)
+
+	// large jump is a restart of the source
+	s.update(packet(30000, 0), 0)
+	require.Equal(t, uint32(1), s.stats().Restarts)
+	require.Equal(t, uint32(1), s.stats().Received)
+
+	// constant delay, then 90 ticks late packet
+	s = newRTPSource(1, 0)
+	s.update(packet(0, 0), 1000)
+	s.update(packet(1, 3000), 4000)
+	require.Zero(t, s.stats().Jitter)
+	s.update(packet(2, 6000), 7090)
+	require.InDelta(t, 90.0/16, s.jitter, 0.001)
+}
+
+func TestClientReceiverReports(t *testing.T) {
+	srv, _ := startTestServer(t)
+	st := addTestStream(srv)
+
+	sdp, err := MarshalSDP("", st.Medias)
+	require.NoError(t, err)
+
+	reports := make(chan []rtcp.Packet, 1)
+
+	address := startFakeServer(t, func(c *Client, req *Request) bool {
+		res := NewResponse(req, OK)
+		switch req.Method {
+		case DESCRIBE:
+			res.Header.Set("Content-Type", "application/sdp")
+			res.Body = sdp
+		case SETUP:
+			res.Header.Set("Transport", "RTP/AVP/TCP;unicast;interleaved=0-1")
+			res.Header.Set("Session", "12345")
+		}
+		if err := c.WriteResponse(res); err != nil || req.Method != PLAY {
+			return err == nil
+		}
+
+		// packet 3 lost
+		for _, seq := range []uint16{1, 2, 4, 5} {
+			packet := &rtp.Packet{Header: rtp.Header{Version: 2, SSRC: 0xCAFE, SequenceNumber: seq}}
+			require.NoError(t, c.writeRTP(0, packet))
+		}
+
+		b, err := rtcp.Marshal([]rtcp.Packet{&rtcp.SenderReport{SSRC: 0xCAFE, NTPTime: 0x1122334455667788}})
+		require.NoError(t, err)
+		require.NoError(t, c.writeRTCP(1, b))
+
+		// wait receiver report on RTCP channel
+		for {
+			header := make([]byte, 4)
+			if _, err = io.ReadFull(c.reader, header); err != nil || header[0] != '$' {
+				return false
+			}
+
+			b = make([]byte, binary.BigEndian.Uint16(header[2:]))
+			if _, err = io.ReadFull(c.reader, b); err != nil {
+				return false
+			}
+
+			if header[1] == 1 {
+				packets, err := rtcp.Unmarshal(b)
+				require.NoError(t, err)
+				reports <- packets
+				return false
+			}
+		}
+	})
+
+	client := NewClient("rtsp://" + address + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Describe())
+	_, err = client.SetupMedia(client.Medias[0])
+	require.NoError(t, err)
+	require.NoError(t, client.Play())
+
+	go func() {
+		_ = client.Handle()
+	}()
+
+	var packets []rtcp.Packet
+	select {
+	case packets = <-reports:
+	case <-time.After(2 * ReportInterval):
+		require.FailNow(t, "no receiver report")
+	}
+
+	require.Len(t, packets, 2)
+
+	rr := packets[0].(*rtcp.ReceiverReport)
+	require.Len(t, rr.Reports, 1)
+	require.Equal(t, uint32(0xCAFE), rr.Reports[0].SSRC)
+	require.Equal(t, uint32(1), rr.Reports[0].TotalLost)
+	require.Equal(t, uint8(256/5), rr.Reports[0].FractionLost)
+	require.Equal(t, uint32(5), rr.Reports[0].LastSequenceNumber)
+	require.Equal(t, uint32(0x33445566), rr.Reports[0].LastSenderReport)
+
+	sdes := packets[1].(*rtcp.SourceDescription)
+	require.Equal(t, rr.SSRC, sdes.Chunks[0].Source)
+
+	require.Equal(t, []SourceStats{{SSRC: 0xCAFE, Received: 4, Lost: 1, MaxSeq: 5}}, client.Receivers[0].Sources())
+}
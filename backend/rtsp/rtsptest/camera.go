@@ -0,0 +1,549 @@
+// Package rtsptest provides fake RTSP camera for integration tests. Camera
+// replays canned SDP and RTP and can simulate misbehaviour of real cameras.
+// It doesn't use rtsp package, so it can't share bugs with the client.
+package rtsptest
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthDigest = "digest"
+	AuthBasic  = "basic"
+)
+
+// Camera - fake RTSP camera, TCP interleaved transport only.
+// UDP SETUP is answered with 461 Unsupported Transport.
+type Camera struct {
+	SDP    []byte
+	Tracks [][]Packet // canned RTP for each media of SDP
+	Loop   bool       // replay tracks in loop
+
+	Server      string // Server header
+	ContentBase string // Content-Base header, request URL if empty
+
+	// 401 challenge until request has valid credentials
+	Username   string
+	Password   string
+	AuthMethod string // AuthDigest if empty
+	Challenge  string // raw WWW-Authenticate instead of generated one
+
+	// Authorize - custom check instead of Username and Password
+	Authorize func(req *Request) bool
+
+	// Transport returns Transport header for SETUP answer, ex. without interleaved
+	Transport func(requested string, track int) string
+
+	Junk           []byte        // written before each interleaved frame
+	SessionTimeout time.Duration // connection is closed without requests during it
+	ByeAfter       int           // RTCP BYE instead of packet number N
+
+	// Script can answer any request instead of camera, nil to use default logic
+	Script func(req *Request) *Response
+
+	URL string // rtsp://127.0.0.1:port/stream after Start
+
+	ln    net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	reqs  []string
+}
+
+type Request struct {
+	Method string
+	URL    string
+	Header textproto.MIMEHeader
+	Body   []byte
+}
+
+type Response struct {
+	StatusCode int
+	Header     textproto.MIMEHeader
+	Body       []byte
+}
+
+func NewResponse(statusCode int) *Response {
+	return &Response{StatusCode: statusCode, Header: textproto.MIMEHeader{}}
+}
+
+// Start listens on random local port
+func (c *Camera) Start() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	c.ln = ln
+	c.conns = map[net.Conn]struct{}{}
+	c.URL = "rtsp://" + ln.Addr().String() + "/stream"
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			c.mu.Lock()
+			c.conns[conn] = struct{}{}
+			c.mu.Unlock()
+
+			go c.serve(conn)
+		}
+	}()
+
+	return nil
+}
+
+// Close stops listener and breaks all connections
+func (c *Camera) Close() error {
+	err := c.ln.Close()
+
+	c.mu.Lock()
+	for conn := range c.conns {
+		_ = conn.Close()
+	}
+	c.mu.Unlock()
+
+	return err
+}
+
+// Requests returns methods of all received requests, in order
+func (c *Camera) Requests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.reqs...)
+}
+
+// session - state of one connection
+type session struct {
+	cam    *Camera
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMu sync.Mutex
+
+	id       string
+	channels map[int]byte // track to RTP channel
+	stop     chan struct{}
+}
+
+func (c *Camera) serve(conn net.Conn) {
+	s := &session{
+		cam:      c,
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		channels: map[int]byte{},
+	}
+
+	defer func() {
+		s.stopReplay()
+		_ = conn.Close()
+
+		c.mu.Lock()
+		delete(c.conns, conn)
+		c.mu.Unlock()
+	}()
+
+	for {
+		if s.id != "" && c.SessionTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(c.SessionTimeout))
+		}
+
+		req, err := s.readRequest()
+		if err != nil {
+			return
+		}
+		if req == nil {
+			continue // interleaved frame from client
+		}
+
+		c.mu.Lock()
+		c.reqs = append(c.reqs, req.Method)
+		c.mu.Unlock()
+
+		res := c.handle(s, req)
+
+		if err = s.writeResponse(req, res); err != nil || req.Method == "TEARDOWN" {
+			return
+		}
+
+		if req.Method == "PLAY" && res.StatusCode == 200 {
+			s.startReplay()
+		}
+	}
+}
+
+func (c *Camera) handle(s *session, req *Request) *Response {
+	if c.Script != nil {
+		if res := c.Script(req); res != nil {
+			return res
+		}
+	}
+
+	if res := c.authorize(req); res != nil {
+		return res
+	}
+
+	res := NewResponse(200)
+
+	switch req.Method {
+	case "OPTIONS":
+		res.Header.Set("Public", "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER, SET_PARAMETER")
+
+	case "DESCRIBE":
+		base := c.ContentBase
+		if base == "" {
+			base = req.URL + "/"
+		}
+		res.Header.Set("Content-Base", base)
+		res.Header.Set("Content-Type", "application/sdp")
+		res.Body = c.SDP
+
+	case "SETUP":
+		track := c.track(req.URL, len(s.channels))
+		if track < 0 {
+			return NewResponse(404)
+		}
+
+		requested := req.Header.Get("Transport")
+		channel, ok := interleaved(requested)
+		if !ok {
+			return NewResponse(461)
+		}
+		s.channels[track] = channel
+
+		if s.id == "" {
+			s.id = strconv.FormatInt(time.Now().UnixNano(), 16)
+		}
+		session := s.id
+		if c.SessionTimeout > 0 {
+			session += ";timeout=" + strconv.Itoa(int((c.SessionTimeout+time.Second-1)/time.Second))
+		}
+		res.Header.Set("Session", session)
+
+		if c.Transport != nil {
+			res.Header.Set("Transport", c.Transport(requested, track))
+		} else {
+			res.Header.Set("Transport", fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1))
+		}
+
+	case "PLAY":
+		if len(s.channels) == 0 {
+			return NewResponse(455)
+		}
+		res.Header.Set("Session", s.id)
+		res.Header.Set("Range", "npt=0.000-")
+
+	case "PAUSE":
+		s.stopReplay()
+
+	case "TEARDOWN", "GET_PARAMETER", "SET_PARAMETER":
+
+	default:
+		return NewResponse(501)
+	}
+
+	return res
+}
+
+const realm = "rtsptest"
+const nonce = "0123456789abcdef"
+
+// authorize returns 401 response if request has no valid credentials
+func (c *Camera) authorize(req *Request) *Response {
+	if c.Username == "" && c.Authorize == nil {
+		return nil
+	}
+
+	auth := req.Header.Get("Authorization")
+
+	switch {
+	case c.Authorize != nil:
+		if c.Authorize(req) {
+			return nil
+		}
+	case c.AuthMethod == AuthBasic:
+		basic := base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password))
+		if auth == "Basic "+basic {
+			return nil
+		}
+	default:
+		if strings.HasPrefix(auth, "Digest ") && c.validDigest(req.Method, auth[7:]) {
+			return nil
+		}
+	}
+
+	res := NewResponse(401)
+	switch {
+	case c.Challenge != "":
+		res.Header.Set("WWW-Authenticate", c.Challenge)
+	case c.AuthMethod == AuthBasic:
+		res.Header.Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+	default:
+		res.Header.Set("WWW-Authenticate", `Digest realm="`+realm+`", nonce="`+nonce+`"`)
+	}
+	return res
+}
+
+func (c *Camera) validDigest(method, header string) bool {
+	params := map[string]string{}
+	for _, param := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		params[key] = strings.Trim(value, `"`)
+	}
+
+	if params["username"] != c.Username || params["realm"] != realm || params["nonce"] != nonce {
+		return false
+	}
+
+	ha1 := md5hex(c.Username + ":" + realm + ":" + c.Password)
+	ha2 := md5hex(method + ":" + params["uri"])
+
+	var response string
+	if qop := params["qop"]; qop != "" {
+		response = md5hex(ha1 + ":" + nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":" + qop + ":" + ha2)
+	} else {
+		response = md5hex(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	return params["response"] == response
+}
+
+func md5hex(s string) string {
+	b := md5.Sum([]byte(s))
+	return hex.EncodeToString(b[:])
+}
+
+// track returns media index by control from SDP or next index
+func (c *Camera) track(uri string, next int) int {
+	for i, control := range controls(c.SDP) {
+		if control != "" && control != "*" && strings.HasSuffix(uri, control) {
+			return i
+		}
+	}
+	if next < len(c.Tracks) || next < len(controls(c.SDP)) {
+		return next
+	}
+	return -1
+}
+
+// controls returns a=control values for each media of SDP
+func controls(sdp []byte) []string {
+	var values []string
+	for _, line := range strings.Split(string(sdp), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			values = append(values, "")
+		case strings.HasPrefix(line, "a=control:") && len(values) > 0:
+			values[len(values)-1] = line[10:]
+		}
+	}
+	return values
+}
+
+// interleaved returns RTP channel from requested TCP transport
+func interleaved(transport string) (byte, bool) {
+	for _, param := range strings.Split(transport, ";") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(param), "interleaved="); ok {
+			first, _, _ := strings.Cut(value, "-")
+			i, err := strconv.Atoi(first)
+			return byte(i), err == nil
+		}
+	}
+	return 0, false
+}
+
+// readRequest returns nil request for interleaved frame from client, ex. RTCP
+func (s *session) readRequest() (*Request, error) {
+	b, err := s.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if b[0] == '$' {
+		header := make([]byte, 4)
+		if _, err = io.ReadFull(s.reader, header); err != nil {
+			return nil, err
+		}
+		_, err = s.reader.Discard(int(binary.BigEndian.Uint16(header[2:])))
+		return nil, err
+	}
+
+	tp := textproto.NewReader(s.reader)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, errors.New("rtsptest: wrong request: " + line)
+	}
+
+	req := &Request{Method: fields[0], URL: fields[1]}
+	if req.Header, err = tp.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+
+	if n, _ := strconv.Atoi(req.Header.Get("Content-Length")); n > 0 {
+		req.Body = make([]byte, n)
+		if _, err = io.ReadFull(s.reader, req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+func (s *session) writeResponse(req *Request, res *Response) error {
+	if res.Header == nil {
+		res.Header = textproto.MIMEHeader{}
+	}
+	res.Header.Set("CSeq", req.Header.Get("CSeq"))
+	if s.cam.Server != "" && res.Header.Get("Server") == "" {
+		res.Header.Set("Server", s.cam.Server)
+	}
+	if len(res.Body) > 0 {
+		res.Header.Set("Content-Length", strconv.Itoa(len(res.Body)))
+	}
+
+	b := []byte(fmt.Sprintf("RTSP/1.0 %d %s\r\n", res.StatusCode, statusText(res.StatusCode)))
+	for key, values := range res.Header {
+		for _, value := range values {
+			b = append(b, key+": "+value+"\r\n"...)
+		}
+	}
+	b = append(b, "\r\n"...)
+	b = append(b, res.Body...)
+
+	return s.write(b)
+}
+
+func (s *session) write(b []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := s.conn.Write(b)
+	return err
+}
+
+func (s *session) writeFrame(channel byte, data []byte) error {
+	b := make([]byte, 0, len(s.cam.Junk)+4+len(data))
+	b = append(b, s.cam.Junk...)
+	b = append(b, '$', channel)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return s.write(append(b, data...))
+}
+
+func (s *session) startReplay() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	go s.replay(s.stop)
+}
+
+func (s *session) stopReplay() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// replay sends packets of all setup tracks in order of offsets
+func (s *session) replay(stop chan struct{}) {
+	type frame struct {
+		channel byte
+		packet  Packet
+	}
+
+	var frames []frame
+	for track, channel := range s.channels {
+		if track < len(s.cam.Tracks) {
+			for _, packet := range s.cam.Tracks[track] {
+				frames = append(frames, frame{channel: channel, packet: packet})
+			}
+		}
+	}
+	if len(frames) == 0 {
+		return
+	}
+
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].packet.Offset < frames[j].packet.Offset
+	})
+
+	var sent int
+
+	for {
+		start := time.Now()
+
+		for _, f := range frames {
+			if d := f.packet.Offset - time.Since(start); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-stop:
+					return
+				}
+			}
+
+			if sent++; sent == s.cam.ByeAfter {
+				_ = s.writeFrame(f.channel+1, bye(f.packet.Data))
+				return
+			}
+
+			if err := s.writeFrame(f.channel, f.packet.Data); err != nil {
+				return
+			}
+		}
+
+		if !s.cam.Loop {
+			return
+		}
+	}
+}
+
+// bye returns RTCP BYE for SSRC of RTP packet
+func bye(rtp []byte) []byte {
+	b := []byte{0x81, 203, 0, 1, 0, 0, 0, 0}
+	if len(rtp) >= 12 {
+		copy(b[4:], rtp[8:12])
+	}
+	return b
+}
+
+func statusText(code int) string {
+	switch code {
+	case 200:
+		return "OK"
+	case 401:
+		return "Unauthorized"
+	case 404:
+		return "Not Found"
+	case 455:
+		return "Method Not Valid in This State"
+	case 461:
+		return "Unsupported Transport"
+	case 501:
+		return "Not Implemented"
+	}
+	return "Status " + strconv.Itoa(code)
+}
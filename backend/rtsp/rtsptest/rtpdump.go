@@ -0,0 +1,122 @@
+package rtsptest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Packet - canned RTP packet of one track
+type Packet struct {
+	Offset time.Duration // from the start of replay
+	Data   []byte
+}
+
+const rtpdumpHeader = "#!rtpplay1.0 "
+
+// ReadRTPDump reads RTP packets from rtpdump file (rtptools, Wireshark export).
+// RTCP packets are skipped.
+func ReadRTPDump(r io.Reader) ([]Packet, error) {
+	br := bufio.NewReader(r)
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, rtpdumpHeader) {
+		return nil, errors.New("rtsptest: wrong rtpdump header")
+	}
+
+	// start time, source address and port
+	if _, err = io.ReadFull(br, make([]byte, 16)); err != nil {
+		return nil, err
+	}
+
+	var packets []Packet
+
+	b := make([]byte, 8)
+	for {
+		if _, err = io.ReadFull(br, b); err != nil {
+			if err == io.EOF {
+				return packets, nil
+			}
+			return nil, err
+		}
+
+		// length with this header, length of RTP packet (0 for RTCP), offset in ms
+		size := int(binary.BigEndian.Uint16(b))
+		plen := int(binary.BigEndian.Uint16(b[2:]))
+		offset := binary.BigEndian.Uint32(b[4:])
+
+		if size < 8 {
+			return nil, errors.New("rtsptest: wrong rtpdump packet")
+		}
+
+		data := make([]byte, size-8)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+
+		if plen == 0 {
+			continue
+		}
+		if plen < len(data) {
+			data = data[:plen]
+		}
+
+		packets = append(packets, Packet{Offset: time.Duration(offset) * time.Millisecond, Data: data})
+	}
+}
+
+// WriteRTPDump writes packets in rtpdump format
+func WriteRTPDump(w io.Writer, packets []Packet) error {
+	if _, err := io.WriteString(w, rtpdumpHeader+"127.0.0.1/5000\n"); err != nil {
+		return err
+	}
+	if _, err := w.Write(make([]byte, 16)); err != nil {
+		return err
+	}
+
+	for _, packet := range packets {
+		b := make([]byte, 8, 8+len(packet.Data))
+		binary.BigEndian.PutUint16(b, uint16(8+len(packet.Data)))
+		binary.BigEndian.PutUint16(b[2:], uint16(len(packet.Data)))
+		binary.BigEndian.PutUint32(b[4:], uint32(packet.Offset/time.Millisecond))
+		if _, err := w.Write(append(b, packet.Data...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadCamera creates camera with SDP from file and one rtpdump file per media
+func LoadCamera(sdpFile string, trackFiles ...string) (*Camera, error) {
+	sdp, err := os.ReadFile(sdpFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cam := &Camera{SDP: sdp}
+
+	for _, name := range trackFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		packets, err := ReadRTPDump(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		cam.Tracks = append(cam.Tracks, packets)
+	}
+
+	return cam, nil
+}
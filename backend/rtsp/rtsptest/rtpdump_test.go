@@ -0,0 +1,34 @@
+package rtsptest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTPDump(t *testing.T) {
+	packets := []Packet{
+		{Offset: 0, Data: []byte{0x80, 96, 0, 1, 1, 2, 3}},
+		{Offset: 40 * time.Millisecond, Data: []byte{0x80, 96, 0, 2, 4, 5}},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, WriteRTPDump(buf, packets))
+
+	actual, err := ReadRTPDump(buf)
+	require.NoError(t, err)
+	require.Equal(t, packets, actual)
+
+	_, err = ReadRTPDump(bytes.NewBufferString("wrong\n"))
+	require.Error(t, err)
+}
+
+func TestLoadCamera(t *testing.T) {
+	cam, err := LoadCamera("../testdata/camera.sdp", "../testdata/video.rtpdump", "../testdata/audio.rtpdump")
+	require.NoError(t, err)
+	require.Len(t, cam.Tracks, 2)
+	require.Len(t, cam.Tracks[0], 31)
+	require.Len(t, cam.Tracks[1], 50)
+}
@@ -0,0 +1,223 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+	"github.com/vtpl1/phoring/backend/rtp/codecs"
+)
+
+// PacketMTU - maximum RTP packet size for outgoing packets
+const PacketMTU = 1400
+
+var ErrUnsupportedCodec = errors.New("rtsp: unsupported codec")
+
+// Sender - outgoing track of one sendonly Media, for example ONVIF backchannel,
+// or of published Media
+type Sender struct {
+	Media   *Media
+	Codec   *Codec
+	Channel byte
+
+	client     *Client
+	mu         sync.Mutex
+	packetizer rtp.Packetizer
+	sent       atomic.Uint64
+	dropped    atomic.Uint64
+}
+
+func NewSender(media *Media, channel byte) *Sender {
+	s := &Sender{Media: media, Channel: channel}
+
+	// select first codec that we can packetize
+	for _, codec := range media.Codecs {
+		if NewPayloader(codec) != nil {
+			s.Codec = codec
+			break
+		}
+	}
+
+	return s
+}
+
+// NewPayloader returns RTP payloader for codec or nil
+func NewPayloader(codec *Codec) rtp.Payloader {
+	switch codec.Name {
+	case CodecPCMU, CodecPCMA:
+		return &codecs.G711Payloader{}
+	case CodecG722:
+		return &codecs.G722Payloader{}
+	case CodecH264:
+		return &codecs.H264Payloader{}
+	case CodecVP8:
+		return &codecs.VP8Payloader{}
+	case CodecVP9:
+		return &codecs.VP9Payloader{}
+	case CodecAV1:
+		return &codecs.AV1Payloader{}
+	case CodecOpus:
+		return &codecs.OpusPayloader{}
+	}
+	return nil
+}
+
+// Write packetizes raw audio frame (PCMU, PCMA or G722) and sends it.
+// Audio is dropped until server acknowledges PLAY.
+func (s *Sender) Write(payload []byte) (int, error) {
+	if s.Codec != nil {
+		switch s.Codec.Name {
+		case CodecPCMU, CodecPCMA, CodecG722:
+		default:
+			return 0, ErrUnsupportedCodec
+		}
+	}
+
+	// one byte is one RTP timestamp tick for G711 and G722 (8000 Hz clock rate)
+	for b := payload; len(b) > 0; {
+		n := min(len(b), PacketMTU-12)
+		if err := s.WriteSample(b[:n], uint32(n)); err != nil {
+			return len(payload) - len(b), err
+		}
+		b = b[n:]
+	}
+
+	return len(payload), nil
+}
+
+// WriteSample packetizes one frame (H264 access unit in Annex B format, audio
+// frame...) and sends it. Duration is in codec clock rate units.
+func (s *Sender) WriteSample(payload []byte, duration uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.packetizer == nil {
+		if s.Codec == nil {
+			return ErrUnsupportedCodec
+		}
+
+		payloader := NewPayloader(s.Codec)
+		if payloader == nil {
+			return ErrUnsupportedCodec
+		}
+
+		clockRate := s.Codec.ClockRate
+		if clockRate == 0 {
+			clockRate = 8000
+		}
+
+		s.packetizer = rtp.NewPacketizer(
+			PacketMTU, s.Codec.PayloadType, rand.Uint32(), payloader, rtp.NewRandomSequencer(), clockRate,
+		)
+	}
+
+	for _, packet := range s.packetizer.Packetize(payload, duration) {
+		if err := s.WriteRTP(packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteRTP sends already packetized RTP packet
+func (s *Sender) WriteRTP(packet *rtp.Packet) error {
+	if s.client == nil || !s.client.playOK.Load() {
+		s.dropped.Add(1)
+		return nil
+	}
+
+	if err := s.client.writeRTP(s.Channel, packet); err != nil {
+		return err
+	}
+
+	s.sent.Add(1)
+	return nil
+}
+
+// Sent returns number of packets written to connection
+func (s *Sender) Sent() uint64 {
+	return s.sent.Load()
+}
+
+// Dropped returns number of packets skipped before PLAY was acknowledged
+func (s *Sender) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Sender returns track for channel or nil
+func (c *Client) Sender(channel byte) *Sender {
+	c.receiversMu.RLock()
+	defer c.receiversMu.RUnlock()
+
+	for _, s := range c.Senders {
+		if s.Channel == channel {
+			return s
+		}
+	}
+	return nil
+}
+
+func (c *Client) addSender(media *Media, channel byte) *Sender {
+	c.receiversMu.Lock()
+	defer c.receiversMu.Unlock()
+
+	for _, s := range c.Senders {
+		if s.Media.Equal(media) {
+			s.Channel = channel
+			return s
+		}
+	}
+
+	s := NewSender(media, channel)
+	s.client = c
+	c.Senders = append(c.Senders, s)
+	return s
+}
+
+// writeRTP sends packet to UDP socket of channel or as interleaved frame
+func (c *Client) writeRTP(channel byte, packet *rtp.Packet) error {
+	for _, u := range c.udpConns() {
+		if u.channel == channel {
+			if u.rtpAddr == nil {
+				return nil
+			}
+			b, err := packet.Marshal()
+			if err != nil {
+				return err
+			}
+			_, err = u.rtp.WriteToUDP(b, u.rtpAddr)
+			return err
+		}
+	}
+
+	size := packet.MarshalSize()
+
+	// `$` + 1B channel number + 2B size
+	buf := make([]byte, 4+size)
+	buf[0] = '$'
+	buf[1] = channel
+	binary.BigEndian.PutUint16(buf[2:], uint16(size))
+	if _, err := packet.MarshalTo(buf[4:]); err != nil {
+		return err
+	}
+
+	return c.write(buf)
+}
+
+// write sends data to connection, safe for concurrent use
+func (c *Client) write(b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.setWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+
+	_, err := c.conn.Write(b)
+	return err
+}
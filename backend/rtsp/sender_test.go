@@ -0,0 +1,57 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func TestSenderBackchannel(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	defer conn1.Close()
+	defer conn2.Close()
+
+	c := &Client{conn: conn1, timeout: time.Second}
+
+	media := &Media{
+		Kind: KindAudio, Direction: DirectionSendonly,
+		Codecs: []*Codec{{Name: CodecAAC}, {Name: CodecPCMA, ClockRate: 8000, PayloadType: 8}},
+	}
+	c.Medias = []*Media{{Kind: KindVideo}, media}
+
+	s := c.addSender(media, 2)
+	require.Equal(t, s, c.Sender(2))
+	require.Equal(t, CodecPCMA, s.Codec.Name)
+
+	// before PLAY acknowledged
+	n, err := s.Write(make([]byte, 160))
+	require.NoError(t, err)
+	require.Equal(t, 160, n)
+	require.Equal(t, uint64(1), s.Dropped())
+
+	c.playOK.Store(true)
+
+	go func() {
+		_, _ = s.Write(make([]byte, 160))
+	}()
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn2, buf)
+	require.NoError(t, err)
+	require.Equal(t, byte('$'), buf[0])
+	require.Equal(t, byte(2), buf[1])
+
+	buf = make([]byte, binary.BigEndian.Uint16(buf[2:]))
+	_, err = io.ReadFull(conn2, buf)
+	require.NoError(t, err)
+
+	packet := &rtp.Packet{}
+	require.NoError(t, packet.Unmarshal(buf))
+	require.Equal(t, uint8(8), packet.PayloadType)
+	require.Len(t, packet.Payload, 160)
+}
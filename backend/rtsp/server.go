@@ -0,0 +1,550 @@
+package rtsp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+// Stream - registered server path. Source packets are written to Receivers,
+// one per media, and each playing session subscribes its own Handler.
+type Stream struct {
+	Path      string
+	Medias    []*Media
+	Receivers []*Receiver
+}
+
+func NewStream(path string, medias ...*Media) *Stream {
+	st := &Stream{Path: path}
+	for i, media := range medias {
+		// clone media with own control for SDP
+		clone := *media
+		clone.ID = "trackID=" + strconv.Itoa(i)
+		st.Medias = append(st.Medias, &clone)
+		st.Receivers = append(st.Receivers, NewReceiver(&clone, byte(i*2)))
+	}
+	return st
+}
+
+// Close removes handlers of all sessions
+func (st *Stream) Close() {
+	for _, receiver := range st.Receivers {
+		receiver.Close()
+	}
+}
+
+// Server serves registered streams to RTSP clients (VLC, FFmpeg) over
+// TCP interleaved and UDP transports. Publishers (FFmpeg, encoders) can push
+// new streams with ANNOUNCE and RECORD.
+type Server struct {
+	// Auth checks credentials of clients, no auth if nil
+	Auth *Auth
+	// Realm for WWW-Authenticate header
+	Realm string
+
+	mu       sync.Mutex
+	streams  map[string]*Stream
+	conns    map[*Client]struct{}
+	listener net.Listener
+}
+
+func NewServer() *Server {
+	return &Server{
+		streams: map[string]*Stream{},
+		conns:   map[*Client]struct{}{},
+	}
+}
+
+// AddStream registers path, for example `camera1` for rtsp://host:8554/camera1
+func (s *Server) AddStream(path string, medias ...*Media) *Stream {
+	st := NewStream(normalizePath(path), medias...)
+
+	s.mu.Lock()
+	if old := s.streams[st.Path]; old != nil {
+		old.Close()
+	}
+	s.streams[st.Path] = st
+	s.mu.Unlock()
+
+	return st
+}
+
+func (s *Server) Stream(path string) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[normalizePath(path)]
+}
+
+func (s *Server) RemoveStream(path string) {
+	s.mu.Lock()
+	st := s.streams[normalizePath(path)]
+	delete(s.streams, normalizePath(path))
+	s.mu.Unlock()
+
+	if st != nil {
+		st.Close()
+	}
+}
+
+func (s *Server) ListenAndServe(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections until Close
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops listener and breaks all sessions
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.abort()
+	}
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// serverSession - tracks of one RTSP session on a server connection
+type serverSession struct {
+	stream   *Stream
+	channels map[int]byte // receiver index > session channel
+
+	publish  bool     // session was started with ANNOUNCE
+	controls []string // media controls from publisher SDP
+}
+
+// controlIndex returns publisher media index for SETUP URL
+func (sess *serverSession) controlIndex(u *url.URL) int {
+	for i, control := range sess.controls {
+		if control == "" {
+			continue
+		}
+		if control == u.String() || strings.HasSuffix(u.Path, "/"+control) {
+			return i
+		}
+	}
+	if len(sess.controls) == 1 {
+		return 0
+	}
+	return -1
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	c := &Client{
+		conn:    conn,
+		reader:  bufio.NewReaderSize(conn, BufferSize),
+		timeout: time.Second * 60,
+	}
+	c.setState(StateConn)
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	sess := &serverSession{channels: map[int]byte{}}
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		// published stream lives while publisher connected
+		if sess.publish && s.streams[sess.stream.Path] == sess.stream {
+			delete(s.streams, sess.stream.Path)
+		}
+		s.mu.Unlock()
+
+		if sess.publish {
+			sess.stream.Close()
+		}
+
+		c.closeUDP()
+		_ = conn.Close()
+	}()
+
+	for c.State() != StateNone {
+		req, err := c.ReadRequest()
+		if err != nil {
+			return
+		}
+
+		res := s.handleRequest(c, sess, req)
+		if err = c.WriteResponse(res); err != nil {
+			return
+		}
+
+		if res.StatusCode != OK {
+			continue
+		}
+
+		switch req.Method {
+		case PLAY:
+			s.play(c, sess)
+			return
+		case RECORD:
+			s.record(c)
+			return
+		case TEARDOWN:
+			return
+		}
+	}
+}
+
+func (s *Server) handleRequest(c *Client, sess *serverSession, req *Request) *Response {
+	if req.Method != OPTIONS && !s.validate(req) {
+		res := NewResponse(req, Unauthorized)
+		realm := s.Realm
+		if realm == "" {
+			realm = "phoring"
+		}
+		res.Header["WWW-Authenticate"] = s.Auth.Challenge(realm)
+		return res
+	}
+
+	switch req.Method {
+	case OPTIONS:
+		res := NewResponse(req, OK)
+		res.Header.Set("Public", strings.Join([]string{
+			OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER, SET_PARAMETER, ANNOUNCE, RECORD,
+		}, ", "))
+		return res
+
+	case GET_PARAMETER, SET_PARAMETER:
+		return NewResponse(req, OK)
+
+	case DESCRIBE:
+		st := s.Stream(req.URL.Path)
+		if st == nil {
+			return NewResponse(req, NotFound)
+		}
+
+		body, err := MarshalSDP(st.Path, st.Medias)
+		if err != nil {
+			return NewResponse(req, InternalServerError)
+		}
+
+		res := NewResponse(req, OK)
+		res.Header.Set("Content-Type", "application/sdp")
+		res.Header.Set("Content-Base", contentBase(req))
+		res.Body = body
+		return res
+
+	case ANNOUNCE:
+		return s.announce(sess, req)
+
+	case SETUP:
+		return s.setup(c, sess, req)
+
+	case PLAY:
+		if sess.publish || len(sess.channels) == 0 {
+			return NewResponse(req, MethodNotValidInThisState)
+		}
+		res := NewResponse(req, OK)
+		res.Header.Set("Range", "npt=0.000-")
+		return res
+
+	case RECORD:
+		if !sess.publish || len(sess.channels) == 0 {
+			return NewResponse(req, MethodNotValidInThisState)
+		}
+		return NewResponse(req, OK)
+
+	case TEARDOWN:
+		return NewResponse(req, OK)
+	}
+
+	return NewResponse(req, NotImplemented)
+}
+
+// announce registers new stream from publisher SDP
+func (s *Server) announce(sess *serverSession, req *Request) *Response {
+	if sess.stream != nil {
+		return NewResponse(req, MethodNotValidInThisState)
+	}
+
+	medias, err := UnmarshalSDP(req.Body)
+	if err != nil || len(medias) == 0 {
+		return NewResponse(req, BadRequest)
+	}
+
+	path := normalizePath(req.URL.Path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// path already served or published by someone else
+	if s.streams[path] != nil {
+		return NewResponse(req, Forbidden)
+	}
+
+	sess.stream = NewStream(path, medias...)
+	sess.publish = true
+	for _, media := range medias {
+		sess.controls = append(sess.controls, media.ID)
+	}
+
+	s.streams[path] = sess.stream
+
+	return NewResponse(req, OK)
+}
+
+func (s *Server) validate(req *Request) bool {
+	if s.Auth == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Auth.Validate(req)
+}
+
+func (s *Server) setup(c *Client, sess *serverSession, req *Request) *Response {
+	var st *Stream
+	var index int
+
+	if sess.publish {
+		st = sess.stream
+		index = sess.controlIndex(req.URL)
+	} else {
+		var path string
+		path, index = splitTrackPath(req.URL.Path)
+
+		if st = s.Stream(path); st == nil {
+			return NewResponse(req, NotFound)
+		}
+	}
+	if sess.stream != nil && sess.stream != st {
+		return NewResponse(req, AggregateOperationNotAllowed)
+	}
+	if index < 0 && len(st.Receivers) == 1 {
+		index = 0
+	}
+	if index < 0 || index >= len(st.Receivers) {
+		return NewResponse(req, NotFound)
+	}
+
+	th, err := c.acceptTransport(req.Header.Get("Transport"), byte(index*2))
+	if err != nil {
+		return NewResponse(req, UnsupportedTransport)
+	}
+
+	sess.stream = st
+	sess.channels[index] = byte(th.Interleaved[0])
+
+	if sess.publish {
+		// incoming packets of publisher go to stream receivers
+		receiver := st.Receivers[index]
+		c.receiversMu.Lock()
+		receiver.Channel = byte(th.Interleaved[0])
+		c.Receivers = append(c.Receivers, receiver)
+		c.receiversMu.Unlock()
+	}
+
+	if c.session == "" {
+		c.session = strconv.FormatUint(rand.Uint64(), 10)
+	}
+	c.setState(StateSetup)
+
+	res := NewResponse(req, OK)
+	if th.ClientPort != nil {
+		th.Interleaved = nil
+	}
+	res.Header.Set("Transport", th.String())
+	return res
+}
+
+// acceptTransport selects first supported transport from client request
+// and allocates UDP ports if needed. Returned header has interleaved channel
+// set for both transports (virtual for UDP).
+func (c *Client) acceptTransport(header string, channel byte) (*TransportHeader, error) {
+	for _, spec := range strings.Split(header, ",") {
+		th, err := ParseTransportHeader(spec)
+		if err != nil || th.Multicast {
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(th.Protocol, "/TCP"):
+			if th.Interleaved == nil {
+				th.Interleaved = []int{int(channel), int(channel) + 1}
+			}
+			return th, nil
+
+		case th.ClientPort != nil:
+			rtpConn, rtcpConn, err := ListenUDPPair(nil)
+			if err != nil {
+				return nil, err
+			}
+
+			u := &udpConn{channel: channel, rtp: rtpConn, rtcp: rtcpConn}
+			if addr, ok := c.conn.RemoteAddr().(*net.TCPAddr); ok {
+				u.source = addr.IP
+				u.rtpAddr = &net.UDPAddr{IP: addr.IP, Port: th.ClientPort[0]}
+				u.rtcpAddr = &net.UDPAddr{IP: addr.IP, Port: th.ClientPort[1]}
+			}
+			c.addUDP(u)
+
+			port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+			th.Protocol = "RTP/AVP"
+			th.ServerPort = []int{port, port + 1}
+			th.Interleaved = []int{int(channel), int(channel) + 1}
+			return th, nil
+		}
+	}
+
+	return nil, fmt.Errorf("rtsp: unsupported transport: %s", header)
+}
+
+// play sends stream packets to client until TEARDOWN or disconnect
+func (s *Server) play(c *Client, sess *serverSession) {
+	c.mode = ModePassiveConsumer
+	c.setState(StatePlay)
+
+	type subscription struct {
+		receiver *Receiver
+		handler  *Handler
+	}
+
+	var subs []subscription
+
+	for index, channel := range sess.channels {
+		h := &Handler{
+			OnRTP: func(packet *rtp.Packet) {
+				_ = c.writeRTP(channel, packet)
+			},
+		}
+		receiver := sess.stream.Receivers[index]
+		receiver.AddHandler(h)
+		subs = append(subs, subscription{receiver: receiver, handler: h})
+	}
+
+	// stop session when stream removed or publisher gone
+	go func() {
+		<-subs[0].handler.Done()
+		c.abort()
+	}()
+
+	_ = c.Handle()
+
+	// closed connection lets queued packets fail fast
+	c.abort()
+
+	for _, sub := range subs {
+		sub.receiver.RemoveHandler(sub.handler)
+	}
+
+	// handlers write to UDP sockets, which are closed after return
+	for _, sub := range subs {
+		<-sub.handler.Done()
+	}
+}
+
+// record receives publisher packets until TEARDOWN or disconnect
+func (s *Server) record(c *Client) {
+	c.mode = ModePassiveProducer
+	c.setState(StatePlay)
+
+	_ = c.Handle()
+}
+
+// NewResponse creates response with status line for code
+func NewResponse(req *Request, code int) *Response {
+	return &Response{
+		Status:     strconv.Itoa(code) + " " + StatusText(code),
+		StatusCode: code,
+		Header:     map[string][]string{},
+		Request:    req,
+	}
+}
+
+func StatusText(code int) string {
+	switch code {
+	case OK:
+		return "OK"
+	case MovedPermanently:
+		return "Moved Permanently"
+	case MovedTemporarily:
+		return "Moved Temporarily"
+	case BadRequest:
+		return "Bad Request"
+	case Unauthorized:
+		return "Unauthorized"
+	case Forbidden:
+		return "Forbidden"
+	case NotFound:
+		return "Not Found"
+	case MethodNotAllowed:
+		return "Method Not Allowed"
+	case SessionNotFound:
+		return "Session Not Found"
+	case MethodNotValidInThisState:
+		return "Method Not Valid in This State"
+	case AggregateOperationNotAllowed:
+		return "Aggregate Operation Not Allowed"
+	case UnsupportedTransport:
+		return "Unsupported Transport"
+	case InternalServerError:
+		return "Internal Server Error"
+	case NotImplemented:
+		return "Not Implemented"
+	case ServiceUnavailable:
+		return "Service Unavailable"
+	}
+	return "Unknown"
+}
+
+func normalizePath(path string) string {
+	return strings.Trim(path, "/")
+}
+
+// splitTrackPath splits `/camera1/trackID=1` to `camera1` and 1,
+// returns -1 for path without track
+func splitTrackPath(path string) (string, int) {
+	path = normalizePath(path)
+	if i := strings.LastIndex(path, "/trackID="); i >= 0 {
+		if index, err := strconv.Atoi(path[i+9:]); err == nil {
+			return path[:i], index
+		}
+	}
+	return path, -1
+}
+
+func contentBase(req *Request) string {
+	u := *req.URL
+	u.User = nil
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
@@ -0,0 +1,211 @@
+package rtsp
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func startTestServer(t *testing.T) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := NewServer()
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return srv, ln.Addr().String()
+}
+
+func TestServerPlay(t *testing.T) {
+	for _, transport := range []string{TransportTCP, TransportUDP} {
+		t.Run(transport, func(t *testing.T) {
+			srv, address := startTestServer(t)
+
+			st := srv.AddStream("camera1", &Media{
+				Kind: KindVideo, Direction: DirectionRecvonly,
+				Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+			})
+
+			client := NewClient("rtsp://" + address + "/camera1")
+			client.Transport = transport
+			require.NoError(t, client.Dial())
+			defer client.Close()
+
+			require.NoError(t, client.Describe())
+			require.Len(t, client.Medias, 1)
+			require.Equal(t, CodecH264, client.Medias[0].Codecs[0].Name)
+
+			channel, err := client.SetupMedia(client.Medias[0])
+			require.NoError(t, err)
+			require.NoError(t, client.Play())
+
+			received := make(chan *rtp.Packet, 10)
+			client.Receiver(channel).AddHandler(&Handler{
+				OnRTP: func(packet *rtp.Packet) { received <- packet },
+			})
+
+			go func() {
+				_ = client.Handle()
+			}()
+
+			timeout := time.After(3 * time.Second)
+			for seq := uint16(0); ; seq++ {
+				st.Receivers[0].WriteRTP(&rtp.Packet{
+					Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: seq},
+					Payload: []byte{0x65, 1, 2, 3},
+				})
+
+				select {
+				case packet := <-received:
+					require.Equal(t, uint8(96), packet.PayloadType)
+					require.Equal(t, []byte{0x65, 1, 2, 3}, packet.Payload)
+					return
+				case <-time.After(10 * time.Millisecond):
+				case <-timeout:
+					t.Fatal("no packets")
+				}
+			}
+		})
+	}
+}
+
+func TestServerPlayState(t *testing.T) {
+	srv, address := startTestServer(t)
+	srv.AddStream("camera1", &Media{
+		Kind: KindVideo, Direction: DirectionRecvonly,
+		Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+	})
+
+	client := NewClient("rtsp://" + address + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Describe())
+	_, err := client.SetupMedia(client.Medias[0])
+	require.NoError(t, err)
+
+	res, err := client.Do(&Request{Method: PLAY, URL: client.URL})
+	require.NoError(t, err)
+
+	// server doesn't support pause, but must answer
+	res, err = client.Do(&Request{Method: PAUSE, URL: client.URL})
+	require.Error(t, err)
+	require.Equal(t, MethodNotValidInThisState, res.StatusCode)
+
+	res, err = client.Do(&Request{Method: PLAY, URL: client.URL})
+	require.NoError(t, err)
+	require.Equal(t, OK, res.StatusCode)
+}
+
+func TestServerContentLength(t *testing.T) {
+	_, address := startTestServer(t)
+
+	for _, length := range []string{"-1", "abc", strconv.Itoa(BufferSize + 1)} {
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("OPTIONS rtsp://" + address + "/camera1 RTSP/1.0\r\n" +
+			"CSeq: 1\r\nContent-Length: " + length + "\r\n\r\n"))
+		require.NoError(t, err)
+
+		// server drops connection without response
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF, length)
+		_ = conn.Close()
+	}
+
+	client := NewClient("rtsp://" + address + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+	require.NoError(t, client.Options())
+}
+
+func TestServerNotFound(t *testing.T) {
+	_, address := startTestServer(t)
+
+	client := NewClient("rtsp://" + address + "/unknown")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.Error(t, client.Describe())
+}
+
+func TestSplitTrackPath(t *testing.T) {
+	path, index := splitTrackPath("/camera1/trackID=1")
+	require.Equal(t, "camera1", path)
+	require.Equal(t, 1, index)
+
+	path, index = splitTrackPath("/camera1/")
+	require.Equal(t, "camera1", path)
+	require.Equal(t, -1, index)
+}
+
+func TestServerRecord(t *testing.T) {
+	srv, address := startTestServer(t)
+
+	publisher := NewClient("rtsp://" + address + "/live")
+	require.NoError(t, publisher.Dial())
+	defer publisher.Close()
+
+	publisher.Medias = []*Media{{
+		Kind: KindVideo, Direction: DirectionSendonly, ID: "streamid=0",
+		Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+	}}
+	require.NoError(t, publisher.Announce())
+
+	st := srv.Stream("live")
+	require.NotNil(t, st)
+	require.Equal(t, CodecH264, st.Medias[0].Codecs[0].Name)
+
+	// path is busy
+	other := NewClient("rtsp://" + address + "/live")
+	require.NoError(t, other.Dial())
+	other.Medias = publisher.Medias
+	require.Error(t, other.Announce())
+	_ = other.Close()
+
+	req := &Request{
+		Method: SETUP,
+		URL:    publisher.URL.JoinPath("streamid=0"),
+		Header: map[string][]string{
+			"Transport": {"RTP/AVP/TCP;unicast;interleaved=0-1;mode=record"},
+		},
+	}
+	_, err := publisher.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, publisher.Record())
+
+	received := make(chan *rtp.Packet, 1)
+	st.Receivers[0].AddHandler(&Handler{
+		OnRTP: func(packet *rtp.Packet) { received <- packet },
+	})
+
+	require.NoError(t, publisher.writeRTP(0, &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1},
+		Payload: []byte{0x65, 1, 2, 3},
+	}))
+
+	select {
+	case packet := <-received:
+		require.Equal(t, uint16(1), packet.SequenceNumber)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no packets")
+	}
+
+	// stream removed after publisher disconnect
+	_ = publisher.Close()
+	require.Eventually(t, func() bool {
+		return srv.Stream("live") == nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
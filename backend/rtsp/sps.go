@@ -0,0 +1,573 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errSPS = errors.New("rtsp: wrong SPS")
+
+// SPS - video parameters from H264 or H265 sequence parameter set
+type SPS struct {
+	Codec   string // CodecH264 or CodecH265
+	Profile string // Baseline, Main, High...
+	Level   string // 3.0, 4.1...
+	Width   int
+	Height  int
+	FPS     float64 // from VUI timing info, zero if not present
+}
+
+func (s *SPS) String() string {
+	return fmt.Sprintf("%s %s@%s %dx%d", s.Codec, s.Profile, s.Level, s.Width, s.Height)
+}
+
+// ParseH264SPS parses NAL unit with type 7 (with one byte header)
+func ParseH264SPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 || nalu[0]&0x1F != 7 {
+		return nil, errSPS
+	}
+
+	r := &bitReader{buf: unescapeRBSP(nalu[1:])}
+
+	profileIDC := r.readBits(8)
+	constraints := r.readBits(8)
+	levelIDC := r.readBits(8)
+	r.readUE() // seq_parameter_set_id
+
+	chromaFormatIDC := uint32(1)
+
+	switch profileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		if chromaFormatIDC = r.readUE(); chromaFormatIDC == 3 {
+			r.readBit() // separate_colour_plane_flag
+		}
+		r.readUE()  // bit_depth_luma_minus8
+		r.readUE()  // bit_depth_chroma_minus8
+		r.readBit() // qpprime_y_zero_transform_bypass_flag
+		if r.readBit() == 1 {
+			n := 8
+			if chromaFormatIDC == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if r.readBit() == 1 {
+					if i < 6 {
+						r.skipScalingList(16)
+					} else {
+						r.skipScalingList(64)
+					}
+				}
+			}
+		}
+	}
+
+	r.readUE() // log2_max_frame_num_minus4
+
+	switch r.readUE() { // pic_order_cnt_type
+	case 0:
+		r.readUE() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.readBit() // delta_pic_order_always_zero_flag
+		r.readSE()  // offset_for_non_ref_pic
+		r.readSE()  // offset_for_top_to_bottom_field
+		for n := r.readUE(); n > 0 && r.err == nil; n-- {
+			r.readSE() // offset_for_ref_frame
+		}
+	}
+
+	r.readUE()  // max_num_ref_frames
+	r.readBit() // gaps_in_frame_num_value_allowed_flag
+
+	widthMbs := r.readUE() + 1
+	heightMapUnits := r.readUE() + 1
+
+	frameMbsOnly := r.readBit()
+	if frameMbsOnly == 0 {
+		r.readBit() // mb_adaptive_frame_field_flag
+	}
+	r.readBit() // direct_8x8_inference_flag
+
+	width := int(widthMbs) * 16
+	height := int(2-frameMbsOnly) * int(heightMapUnits) * 16
+
+	if r.readBit() == 1 { // frame_cropping_flag
+		cropX, cropY := 1, int(2-frameMbsOnly)
+		switch chromaFormatIDC {
+		case 1:
+			cropX, cropY = 2, 2*cropY
+		case 2:
+			cropX = 2
+		}
+
+		left, right, top, bottom := r.readUE(), r.readUE(), r.readUE(), r.readUE()
+		width -= cropX * int(left+right)
+		height -= cropY * int(top+bottom)
+	}
+
+	if r.err != nil || width <= 0 || height <= 0 {
+		return nil, errSPS
+	}
+
+	sps := &SPS{
+		Codec:   CodecH264,
+		Profile: h264Profile(profileIDC, constraints),
+		Level:   h264Level(levelIDC, constraints),
+		Width:   width,
+		Height:  height,
+	}
+
+	if r.readBit() == 1 { // vui_parameters_present_flag
+		r.skipVUIHeader()
+		if r.readBit() == 1 { // timing_info_present_flag
+			units, scale := r.readBits(32), r.readBits(32)
+			if units > 0 && r.err == nil {
+				sps.FPS = float64(scale) / float64(2*units)
+			}
+		}
+	}
+
+	return sps, nil
+}
+
+// ParseH265SPS parses NAL unit with type 33 (with two bytes header)
+func ParseH265SPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 || (nalu[0]>>1)&0x3F != 33 {
+		return nil, errSPS
+	}
+
+	r := &bitReader{buf: unescapeRBSP(nalu[2:])}
+
+	r.readBits(4) // sps_video_parameter_set_id
+	maxSubLayers := r.readBits(3)
+	r.readBit() // sps_temporal_id_nesting_flag
+
+	profileIDC, tier, levelIDC := r.readProfileTierLevel(maxSubLayers)
+
+	r.readUE() // sps_seq_parameter_set_id
+
+	chromaFormatIDC := r.readUE()
+	if chromaFormatIDC == 3 {
+		r.readBit() // separate_colour_plane_flag
+	}
+
+	width := int(r.readUE())
+	height := int(r.readUE())
+
+	if r.readBit() == 1 { // conformance_window_flag
+		cropX, cropY := 1, 1
+		switch chromaFormatIDC {
+		case 1:
+			cropX, cropY = 2, 2
+		case 2:
+			cropX = 2
+		}
+
+		left, right, top, bottom := r.readUE(), r.readUE(), r.readUE(), r.readUE()
+		width -= cropX * int(left+right)
+		height -= cropY * int(top+bottom)
+	}
+
+	if r.err != nil || width <= 0 || height <= 0 {
+		return nil, errSPS
+	}
+
+	sps := &SPS{
+		Codec:   CodecH265,
+		Profile: h265Profile(profileIDC),
+		Level:   h265Level(levelIDC, tier),
+		Width:   width,
+		Height:  height,
+	}
+
+	r.readUE() // bit_depth_luma_minus8
+	r.readUE() // bit_depth_chroma_minus8
+	log2MaxPocLsb := r.readUE() + 4
+
+	i := maxSubLayers
+	if r.readBit() == 1 { // sps_sub_layer_ordering_info_present_flag
+		i = 0
+	}
+	for ; i <= maxSubLayers; i++ {
+		r.readUE() // sps_max_dec_pic_buffering_minus1
+		r.readUE() // sps_max_num_reorder_pics
+		r.readUE() // sps_max_latency_increase_plus1
+	}
+
+	r.readUE() // log2_min_luma_coding_block_size_minus3
+	r.readUE() // log2_diff_max_min_luma_coding_block_size
+	r.readUE() // log2_min_luma_transform_block_size_minus2
+	r.readUE() // log2_diff_max_min_luma_transform_block_size
+	r.readUE() // max_transform_hierarchy_depth_inter
+	r.readUE() // max_transform_hierarchy_depth_intra
+
+	if r.readBit() == 1 && r.readBit() == 1 { // scaling_list_enabled_flag, sps_scaling_list_data_present_flag
+		r.skipH265ScalingListData()
+	}
+
+	r.readBit()           // amp_enabled_flag
+	r.readBit()           // sample_adaptive_offset_enabled_flag
+	if r.readBit() == 1 { // pcm_enabled_flag
+		r.readBits(8) // pcm_sample_bit_depth_luma_minus1, pcm_sample_bit_depth_chroma_minus1
+		r.readUE()    // log2_min_pcm_luma_coding_block_size_minus3
+		r.readUE()    // log2_diff_max_min_pcm_luma_coding_block_size
+		r.readBit()   // pcm_loop_filter_disabled_flag
+	}
+
+	// up to 64 by spec, broken SPS must not allocate gigabytes
+	numShortTermRefPicSets := r.readUE()
+	if numShortTermRefPicSets > 64 {
+		return nil, errSPS
+	}
+	r.skipShortTermRefPicSets(numShortTermRefPicSets)
+
+	if r.readBit() == 1 { // long_term_ref_pics_present_flag
+		for n := r.readUE(); n > 0 && r.err == nil; n-- {
+			r.readBits(int(log2MaxPocLsb)) // lt_ref_pic_poc_lsb_sps
+			r.readBit()                    // used_by_curr_pic_lt_sps_flag
+		}
+	}
+
+	r.readBit() // sps_temporal_mvp_enabled_flag
+	r.readBit() // strong_intra_smoothing_enabled_flag
+
+	if r.readBit() == 1 { // vui_parameters_present_flag
+		r.skipVUIHeader()
+		r.readBit()           // neutral_chroma_indication_flag
+		r.readBit()           // field_seq_flag
+		r.readBit()           // frame_field_info_present_flag
+		if r.readBit() == 1 { // default_display_window_flag
+			r.readUE()
+			r.readUE()
+			r.readUE()
+			r.readUE()
+		}
+		if r.readBit() == 1 { // vui_timing_info_present_flag
+			units, scale := r.readBits(32), r.readBits(32)
+			if units > 0 && r.err == nil {
+				sps.FPS = float64(scale) / float64(units)
+			}
+		}
+	}
+
+	// VUI is optional, so don't fail on broken tail
+	if r.err != nil {
+		sps.FPS = 0
+	}
+
+	return sps, nil
+}
+
+// ParseH265VPS returns frame rate from vps_timing_info, zero if not present
+func ParseH265VPS(nalu []byte) (float64, error) {
+	if len(nalu) < 4 || (nalu[0]>>1)&0x3F != 32 {
+		return 0, errSPS
+	}
+
+	r := &bitReader{buf: unescapeRBSP(nalu[2:])}
+
+	r.readBits(4) // vps_video_parameter_set_id
+	r.readBits(2) // vps_base_layer_internal_flag, vps_base_layer_available_flag
+	r.readBits(6) // vps_max_layers_minus1
+	maxSubLayers := r.readBits(3)
+	r.readBit()    // vps_temporal_id_nesting_flag
+	r.readBits(16) // vps_reserved_0xffff_16bits
+
+	r.readProfileTierLevel(maxSubLayers)
+
+	i := maxSubLayers
+	if r.readBit() == 1 { // vps_sub_layer_ordering_info_present_flag
+		i = 0
+	}
+	for ; i <= maxSubLayers; i++ {
+		r.readUE()
+		r.readUE()
+		r.readUE()
+	}
+
+	maxLayerID := r.readBits(6)
+	for n := r.readUE(); n > 0 && r.err == nil; n-- { // vps_num_layer_sets_minus1
+		r.readBits(int(maxLayerID) + 1) // layer_id_included_flag
+	}
+
+	var fps float64
+	if r.readBit() == 1 { // vps_timing_info_present_flag
+		units, scale := r.readBits(32), r.readBits(32)
+		if units > 0 {
+			fps = float64(scale) / float64(units)
+		}
+	}
+
+	if r.err != nil {
+		return 0, errSPS
+	}
+
+	return fps, nil
+}
+
+// ParseSpropSPS returns SPS from H264 or H265 fmtp line, nil if not present
+func ParseSpropSPS(codec *Codec) *SPS {
+	var name string
+	switch codec.Name {
+	case CodecH264:
+		name = "sprop-parameter-sets"
+	case CodecH265:
+		name = "sprop-sps"
+	default:
+		return nil
+	}
+
+	for _, param := range strings.Split(codec.FmtpLine, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if key != name {
+			continue
+		}
+
+		for _, s := range strings.Split(value, ",") {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil || len(b) == 0 {
+				continue
+			}
+
+			var sps *SPS
+			if codec.Name == CodecH264 {
+				sps, err = ParseH264SPS(b)
+			} else {
+				sps, err = ParseH265SPS(b)
+			}
+			if err == nil {
+				return sps
+			}
+		}
+	}
+
+	return nil
+}
+
+func h264Profile(idc, constraints uint32) string {
+	switch idc {
+	case 66:
+		if constraints&0x40 != 0 {
+			return "Constrained Baseline"
+		}
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		return "High 10"
+	case 122:
+		return "High 4:2:2"
+	case 244:
+		return "High 4:4:4"
+	}
+	return fmt.Sprintf("Profile %d", idc)
+}
+
+func h264Level(idc, constraints uint32) string {
+	// level 1b for Baseline and Main is coded as 11 with constraint_set3_flag
+	if idc == 11 && constraints&0x10 != 0 {
+		return "1b"
+	}
+	return fmt.Sprintf("%d.%d", idc/10, idc%10)
+}
+
+func h265Profile(idc uint32) string {
+	switch idc {
+	case 1:
+		return "Main"
+	case 2:
+		return "Main 10"
+	case 3:
+		return "Main Still Picture"
+	case 4:
+		return "Range Extensions"
+	}
+	return fmt.Sprintf("Profile %d", idc)
+}
+
+func h265Level(idc, tier uint32) string {
+	// general_level_idc is 30 times the level number
+	s := fmt.Sprintf("%d.%d", idc/30, idc%30/3)
+	if tier == 1 {
+		s += " High"
+	}
+	return s
+}
+
+// unescapeRBSP removes emulation prevention bytes
+func unescapeRBSP(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if i >= 2 && b[i] == 3 && b[i-1] == 0 && b[i-2] == 0 {
+			// skip 0x03 and don't check it as a part of next sequence
+			if i+1 < len(b) {
+				out = append(out, b[i+1])
+				i++
+			}
+			continue
+		}
+		out = append(out, b[i])
+	}
+	return out
+}
+
+// bitReader reads RBSP bits, sets err and returns zeros after end of data
+type bitReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) readBit() uint32 {
+	if r.pos >= len(r.buf)*8 {
+		r.err = errSPS
+		return 0
+	}
+	b := r.buf[r.pos>>3] >> (7 - r.pos&7) & 1
+	r.pos++
+	return uint32(b)
+}
+
+func (r *bitReader) readBits(n int) (v uint32) {
+	for ; n > 0; n-- {
+		v = v<<1 | r.readBit()
+	}
+	return
+}
+
+// readUE reads Exp-Golomb code
+func (r *bitReader) readUE() uint32 {
+	var zeros int
+	for r.readBit() == 0 {
+		if r.err != nil || zeros == 31 {
+			r.err = errSPS
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + r.readBits(zeros)
+}
+
+func (r *bitReader) readSE() int32 {
+	v := r.readUE()
+	if v&1 == 1 {
+		return int32(v+1) / 2
+	}
+	return -int32(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.readSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// skipVUIHeader skips VUI fields common for H264 and H265 before timing info
+func (r *bitReader) skipVUIHeader() {
+	if r.readBit() == 1 { // aspect_ratio_info_present_flag
+		if r.readBits(8) == 255 { // aspect_ratio_idc == Extended_SAR
+			r.readBits(32) // sar_width, sar_height
+		}
+	}
+	if r.readBit() == 1 { // overscan_info_present_flag
+		r.readBit() // overscan_appropriate_flag
+	}
+	if r.readBit() == 1 { // video_signal_type_present_flag
+		r.readBits(4)         // video_format, video_full_range_flag
+		if r.readBit() == 1 { // colour_description_present_flag
+			r.readBits(24)
+		}
+	}
+	if r.readBit() == 1 { // chroma_loc_info_present_flag
+		r.readUE()
+		r.readUE()
+	}
+}
+
+// readProfileTierLevel returns general profile, tier and level
+func (r *bitReader) readProfileTierLevel(maxSubLayers uint32) (profile, tier, level uint32) {
+	r.readBits(2) // general_profile_space
+	tier = r.readBit()
+	profile = r.readBits(5)
+	r.readBits(32) // general_profile_compatibility_flags
+	r.readBits(48) // source flags and reserved bits
+	level = r.readBits(8)
+
+	var subProfile, subLevel []bool
+	for i := uint32(0); i < maxSubLayers; i++ {
+		subProfile = append(subProfile, r.readBit() == 1)
+		subLevel = append(subLevel, r.readBit() == 1)
+	}
+	if maxSubLayers > 0 {
+		r.readBits(2 * int(8-maxSubLayers)) // reserved_zero_2bits
+	}
+	for i := range subProfile {
+		if subProfile[i] {
+			r.readBits(88)
+		}
+		if subLevel[i] {
+			r.readBits(8)
+		}
+	}
+	return
+}
+
+func (r *bitReader) skipH265ScalingListData() {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if r.readBit() == 0 { // scaling_list_pred_mode_flag
+				r.readUE() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			n := min(64, 1<<(4+sizeID<<1))
+			if sizeID > 1 {
+				r.readSE() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < n && r.err == nil; i++ {
+				r.readSE() // scaling_list_delta_coef
+			}
+		}
+	}
+}
+
+func (r *bitReader) skipShortTermRefPicSets(num uint32) {
+	deltaPocs := make([]uint32, 0, num)
+
+	for idx := uint32(0); idx < num && r.err == nil; idx++ {
+		if idx > 0 && r.readBit() == 1 { // inter_ref_pic_set_prediction_flag
+			r.readBit() // delta_rps_sign
+			r.readUE()  // abs_delta_rps_minus1
+
+			var n uint32
+			for j := uint32(0); j <= deltaPocs[idx-1] && r.err == nil; j++ {
+				if r.readBit() == 1 || r.readBit() == 1 { // used_by_curr_pic_flag, use_delta_flag
+					n++
+				}
+			}
+			deltaPocs = append(deltaPocs, n)
+			continue
+		}
+
+		negative, positive := r.readUE(), r.readUE()
+		for j := uint32(0); j < negative+positive && r.err == nil; j++ {
+			r.readUE()  // delta_poc_minus1
+			r.readBit() // used_by_curr_pic_flag
+		}
+		deltaPocs = append(deltaPocs, negative+positive)
+	}
+}
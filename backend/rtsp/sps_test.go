@@ -0,0 +1,64 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSPS(t *testing.T) {
+	for _, test := range []struct {
+		Codec, SPS string
+		Want       SPS
+	}{
+		{
+			Codec: CodecH264, SPS: "Z2QAKKwbGoB4AiflwFuAgICgAAB9AAAOph0MAHz4AAjJdd5caGAD58AARku67lwo",
+			Want: SPS{Codec: CodecH264, Profile: "High", Level: "4.0", Width: 1920, Height: 1080, FPS: 15},
+		},
+		{
+			Codec: CodecH264, SPS: "Z00AKp2oHgCJ+WbgICAoAAADAAgAAAMAfCA=",
+			Want: SPS{Codec: CodecH264, Profile: "Main", Level: "4.2", Width: 1920, Height: 1080, FPS: 7.5},
+		},
+		{
+			Codec: CodecH264, SPS: "Z2QAH6wrQCgC3QDxImo=",
+			Want: SPS{Codec: CodecH264, Profile: "High", Level: "3.1", Width: 1280, Height: 720},
+		},
+		{
+			Codec: CodecH265, SPS: "QgEBAWAAAAMAsAAAAwAAAwB7oAPAgBDlja5JMvTcBAQEAgA=",
+			Want: SPS{Codec: CodecH265, Profile: "Main", Level: "4.1", Width: 1920, Height: 1080},
+		},
+		{
+			Codec: CodecH265, SPS: "QgEBAWAAAAMAkAAAAwAAAwBdoAKAgC0WWVmkkyvAQEAAAAMAQAAAAwZQ",
+			Want: SPS{Codec: CodecH265, Profile: "Main", Level: "3.1", Width: 1280, Height: 720, FPS: 25},
+		},
+	} {
+		t.Run(test.SPS, func(t *testing.T) {
+			b, err := base64.StdEncoding.DecodeString(test.SPS)
+			require.NoError(t, err)
+
+			var sps *SPS
+			if test.Codec == CodecH264 {
+				sps, err = ParseH264SPS(b)
+			} else {
+				sps, err = ParseH265SPS(b)
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.Want, *sps)
+		})
+	}
+
+	_, err := ParseH264SPS([]byte{0x67, 0x64, 0x00})
+	require.Error(t, err)
+
+	// num_short_term_ref_pic_sets = 1<<30
+	b, _ := base64.StdEncoding.DecodeString("QgEBAWAAAAD///////9doAKAgC0WWv8AAAAAIAAAAMA=")
+	_, err = ParseH265SPS(b)
+	require.Error(t, err)
+
+	sps := ParseSpropSPS(&Codec{
+		Name: CodecH264, FmtpLine: "packetization-mode=1;sprop-parameter-sets=Z2QAH6wrQCgC3QDxImo=,aO48sA==",
+	})
+	require.NotNil(t, sps)
+	require.Equal(t, 1280, sps.Width)
+}
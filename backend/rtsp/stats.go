@@ -0,0 +1,258 @@
+package rtsp
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+// statsHistory - seconds of traffic history, the longest bitrate window
+const statsHistory = 60
+
+// Traffic - received packets and bitrate. Bitrate is in bits per second
+// over the last complete seconds.
+type Traffic struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"` // RTP packets with headers
+
+	Bitrate1s  int `json:"bitrate1s"`
+	Bitrate10s int `json:"bitrate10s"`
+	Bitrate60s int `json:"bitrate60s"`
+
+	LastPacketMs int64 `json:"lastPacketMs"` // milliseconds since last packet, zero if none
+}
+
+// TrackStats - snapshot of one Receiver
+type TrackStats struct {
+	Channel byte   `json:"channel"`
+	Kind    string `json:"kind"`
+	Codec   string `json:"codec,omitempty"`
+
+	Traffic
+
+	Lost      int64   `json:"lost"`      // negative for duplicates
+	Reordered uint64  `json:"reordered"` // duplicate or late packets
+	JitterMs  float64 `json:"jitterMs"`  // the biggest of all sources, milliseconds
+
+	FPS      float64 `json:"fps,omitempty"`      // video frames over the last 10 seconds
+	Keyframe int     `json:"keyframe,omitempty"` // frames between the last two keyframes
+
+	Sources []SourceStats `json:"sources,omitempty"`
+}
+
+// ClientStats - snapshot of Client session, see Client.Stats
+type ClientStats struct {
+	URL        string `json:"url"` // without credentials
+	State      State  `json:"state"`
+	Reconnects int    `json:"reconnects"` // by Supervisor after session failure
+
+	Traffic
+
+	Tracks []TrackStats `json:"tracks"`
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// trafficStats - counters of Receiver, guarded by Receiver.statsMu
+type trafficStats struct {
+	packets uint64
+	bytes   uint64
+	first   time.Time
+	last    time.Time
+
+	// per second history, buckets[second%statsHistory] is the current second
+	second       int64
+	bytesPerSec  [statsHistory]uint64
+	framesPerSec [statsHistory]uint32
+
+	lastTS      uint32
+	frames      int
+	keyframe    int // frame index of the last keyframe
+	hasKeyframe bool
+	gop         int
+}
+
+func (t *trafficStats) update(packet *rtp.Packet, now time.Time, kind, codec string) {
+	t.advance(now)
+
+	if t.packets == 0 {
+		t.first = now
+	}
+	newFrame := t.packets == 0 || packet.Timestamp != t.lastTS
+
+	size := uint64(packet.MarshalSize())
+
+	t.packets++
+	t.bytes += size
+	t.last = now
+	t.lastTS = packet.Timestamp
+	t.bytesPerSec[t.second%statsHistory] += size
+
+	if kind != KindVideo {
+		return
+	}
+
+	if newFrame {
+		t.frames++
+		t.framesPerSec[t.second%statsHistory]++
+	}
+
+	if isKeyframe(codec, packet.Payload) && (!t.hasKeyframe || t.keyframe != t.frames) {
+		if t.hasKeyframe {
+			t.gop = t.frames - t.keyframe
+		}
+		t.keyframe = t.frames
+		t.hasKeyframe = true
+	}
+}
+
+// advance moves history to the second of now
+func (t *trafficStats) advance(now time.Time) {
+	sec := now.Unix()
+
+	if sec-t.second >= statsHistory {
+		t.bytesPerSec = [statsHistory]uint64{}
+		t.framesPerSec = [statsHistory]uint32{}
+		t.second = sec
+		return
+	}
+
+	for t.second < sec {
+		t.second++
+		i := t.second % statsHistory
+		t.bytesPerSec[i] = 0
+		t.framesPerSec[i] = 0
+	}
+}
+
+// window returns number of complete seconds for window, limited by first packet
+func (t *trafficStats) window(seconds int64) int64 {
+	if t.packets == 0 {
+		return 0
+	}
+	return min(seconds, t.second-t.first.Unix())
+}
+
+func (t *trafficStats) bitrate(seconds int64) int {
+	n := t.window(seconds)
+	if n <= 0 {
+		return 0
+	}
+
+	var sum uint64
+	for sec := t.second - n; sec < t.second; sec++ {
+		sum += t.bytesPerSec[sec%statsHistory]
+	}
+	return int(sum * 8 / uint64(n))
+}
+
+func (t *trafficStats) fps(seconds int64) float64 {
+	n := t.window(seconds)
+	if n <= 0 {
+		return 0
+	}
+
+	var sum uint32
+	for sec := t.second - n; sec < t.second; sec++ {
+		sum += t.framesPerSec[sec%statsHistory]
+	}
+	return float64(sum) / float64(n)
+}
+
+func (t *trafficStats) snapshot(now time.Time) Traffic {
+	t.advance(now)
+
+	traffic := Traffic{
+		Packets:    t.packets,
+		Bytes:      t.bytes,
+		Bitrate1s:  t.bitrate(1),
+		Bitrate10s: t.bitrate(10),
+		Bitrate60s: t.bitrate(60),
+	}
+	if t.packets > 0 {
+		traffic.LastPacketMs = now.Sub(t.last).Milliseconds()
+	}
+	return traffic
+}
+
+// Stats returns snapshot of track statistics
+func (r *Receiver) Stats() TrackStats {
+	return r.stats(time.Now())
+}
+
+func (r *Receiver) stats(now time.Time) TrackStats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	st := TrackStats{
+		Channel:  r.Channel,
+		Kind:     r.Media.Kind,
+		Traffic:  r.traffic.snapshot(now),
+		FPS:      r.traffic.fps(10),
+		Keyframe: r.traffic.gop,
+		Sources:  r.sourceStats(),
+	}
+	if r.Codec != nil {
+		st.Codec = r.Codec.Name
+	}
+
+	var jitter uint32
+	for _, source := range st.Sources {
+		st.Lost += int64(source.Lost)
+		st.Reordered += uint64(source.Reordered)
+		jitter = max(jitter, source.Jitter)
+	}
+	if clockRate := r.clockRate(); clockRate > 0 {
+		st.JitterMs = float64(jitter) * 1000 / float64(clockRate)
+	}
+
+	return st
+}
+
+// Stats returns snapshot of session and all its tracks. It is safe to call
+// from any goroutine, ex. by monitoring.
+func (c *Client) Stats() ClientStats {
+	now := time.Now()
+
+	st := ClientStats{
+		State:      c.State(),
+		Reconnects: int(c.reconnects.Load()),
+	}
+	if s, ok := c.statsURL.Load().(string); ok {
+		st.URL = s
+	}
+
+	c.receiversMu.RLock()
+	receivers := append([]*Receiver(nil), c.Receivers...)
+	c.receiversMu.RUnlock()
+
+	var hasPackets bool
+
+	for _, receiver := range receivers {
+		track := receiver.stats(now)
+
+		st.Packets += track.Packets
+		st.Bytes += track.Bytes
+		st.Bitrate1s += track.Bitrate1s
+		st.Bitrate10s += track.Bitrate10s
+		st.Bitrate60s += track.Bitrate60s
+		if track.Packets > 0 && (!hasPackets || track.LastPacketMs < st.LastPacketMs) {
+			st.LastPacketMs = track.LastPacketMs
+			hasPackets = true
+		}
+
+		st.Tracks = append(st.Tracks, track)
+	}
+
+	return st
+}
+
+// redactURL removes credentials from URL
+func redactURL(u *url.URL) string {
+	clone := *u
+	clone.User = nil
+	return clone.String()
+}
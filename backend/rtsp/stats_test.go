@@ -0,0 +1,103 @@
+package rtsp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/phoring/backend/rtp"
+)
+
+func TestReceiverStats(t *testing.T) {
+	r := NewReceiver(&Media{
+		Kind:   KindVideo,
+		Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+	}, 0)
+
+	start := time.Unix(1_700_000_000, 0)
+
+	// 25 fps, keyframe every 10 frames, 1000 bytes per frame, 12 seconds
+	var seq uint16
+	for frame := 0; frame < 300; frame++ {
+		payload := make([]byte, 988)
+		if frame%10 == 0 {
+			payload[0] = 0x65
+		} else {
+			payload[0] = 0x41
+		}
+
+		seq++
+		if frame == 100 {
+			seq++ // lost packet
+		}
+
+		packet := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: uint32(frame * 3600), SSRC: 1},
+			Payload: payload,
+		}
+		r.updateStats(packet, start.Add(time.Duration(frame)*40*time.Millisecond))
+
+		if frame == 200 {
+			r.updateStats(packet, start.Add(time.Duration(frame)*40*time.Millisecond)) // duplicate
+		}
+	}
+
+	st := r.stats(start.Add(12 * time.Second))
+	require.Equal(t, CodecH264, st.Codec)
+	require.Equal(t, uint64(301), st.Packets)
+	require.Equal(t, uint64(301_000), st.Bytes)
+	require.Equal(t, 200_000, st.Bitrate1s)
+	require.Equal(t, 200_000+8_000/10, st.Bitrate10s) // with duplicate
+	require.InDelta(t, 25, st.FPS, 0.01)
+	require.Equal(t, 10, st.Keyframe)
+	require.Equal(t, int64(0), st.Lost) // one lost and one duplicate
+	require.Equal(t, uint64(1), st.Reordered)
+	require.Equal(t, int64(40), st.LastPacketMs)
+	require.Len(t, st.Sources, 1)
+
+	// no packets for a long time
+	st = r.stats(start.Add(time.Hour))
+	require.Zero(t, st.Bitrate60s)
+	require.Zero(t, st.FPS)
+	require.Equal(t, uint64(301), st.Packets)
+}
+
+func TestClientStats(t *testing.T) {
+	srv, address := startTestServer(t)
+	st := addTestStream(srv)
+
+	client := NewClient("rtsp://admin:secret@" + address + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.NoError(t, client.Describe())
+	_, err := client.SetupMedia(client.Medias[0])
+	require.NoError(t, err)
+	require.NoError(t, client.Play())
+
+	go func() {
+		_ = client.Handle()
+	}()
+
+	require.Eventually(t, func() bool {
+		st.Receivers[0].WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 96},
+			Payload: []byte{0x65, 1, 2, 3},
+		})
+		return client.Stats().Packets > 0
+	}, 3*time.Second, 10*time.Millisecond)
+
+	stats := client.Stats()
+	require.Equal(t, "rtsp://"+address+"/camera1", stats.URL)
+	require.Equal(t, StatePlay, stats.State)
+	require.Zero(t, stats.Reconnects)
+	require.Len(t, stats.Tracks, 1)
+	require.Equal(t, KindVideo, stats.Tracks[0].Kind)
+
+	b, err := json.Marshal(stats)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"state":"PLAY"`)
+	require.Contains(t, string(b), `"bitrate1s":`)
+	require.Contains(t, string(b), `"lastPacketMs":`)
+}
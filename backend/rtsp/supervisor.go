@@ -0,0 +1,177 @@
+package rtsp
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errStopped = errors.New("rtsp: supervisor stopped")
+
+// Event - session state transition reported by Supervisor
+type Event struct {
+	State   State
+	Err     error // reason for StateNone
+	Attempt int   // reconnect attempt, zero for first connection
+	Time    time.Time
+}
+
+// Supervisor keeps Client session alive. It reconnects after read timeout, EOF
+// or RTCP BYE with exponential backoff and jitter, and setups the same medias.
+// Receivers and Senders of Client survive reconnects, so handlers stay subscribed.
+type Supervisor struct {
+	Client *Client
+
+	// Medias to setup, all medias from first DESCRIBE if empty
+	Medias []*Media
+
+	MinBackoff time.Duration // 1 second if zero
+	MaxBackoff time.Duration // 30 seconds if zero
+
+	events     chan Event
+	stop       chan struct{}
+	once       sync.Once
+	reconnects atomic.Int64
+}
+
+func NewSupervisor(client *Client, medias ...*Media) *Supervisor {
+	return &Supervisor{
+		Client: client,
+		Medias: medias,
+		events: make(chan Event, 16),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Events returns state transitions. Events are dropped if nobody reads them.
+func (s *Supervisor) Events() <-chan Event {
+	return s.events
+}
+
+// Reconnects returns number of reconnect attempts
+func (s *Supervisor) Reconnects() int64 {
+	return s.reconnects.Load()
+}
+
+// Run keeps session alive until Stop
+func (s *Supervisor) Run() error {
+	var attempt int
+
+	for {
+		ts := time.Now()
+
+		err := s.session(attempt)
+		_ = s.Client.Close()
+
+		s.emit(StateNone, err, attempt)
+
+		if s.stopped() {
+			return nil
+		}
+
+		// session was alive long enough, start backoff from the beginning
+		if time.Since(ts) > s.maxBackoff() {
+			attempt = 0
+		}
+
+		attempt++
+		s.reconnects.Add(1)
+		s.Client.reconnects.Add(1)
+
+		timer := time.NewTimer(s.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// Stop breaks current session and Run loop
+func (s *Supervisor) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+		s.Client.abort()
+	})
+}
+
+func (s *Supervisor) session(attempt int) error {
+	c := s.Client
+
+	if err := c.Dial(); err != nil {
+		return err
+	}
+	if s.stopped() {
+		return errStopped
+	}
+	s.emit(StateConn, nil, attempt)
+
+	if err := c.Describe(); err != nil {
+		return err
+	}
+
+	if s.Medias == nil {
+		s.Medias = c.Medias
+	}
+
+	for _, media := range s.Medias {
+		if _, err := c.SetupMedia(media); err != nil {
+			return err
+		}
+	}
+	if s.stopped() {
+		return errStopped
+	}
+	s.emit(StateSetup, nil, attempt)
+
+	if err := c.Play(); err != nil {
+		return err
+	}
+	s.emit(StatePlay, nil, attempt)
+
+	return c.Handle()
+}
+
+func (s *Supervisor) emit(state State, err error, attempt int) {
+	select {
+	case s.events <- Event{State: state, Err: err, Attempt: attempt, Time: time.Now()}:
+	default:
+	}
+}
+
+func (s *Supervisor) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) maxBackoff() time.Duration {
+	if s.MaxBackoff > 0 {
+		return s.MaxBackoff
+	}
+	return 30 * time.Second
+}
+
+// backoff returns delay before attempt, doubles every attempt with equal jitter
+func (s *Supervisor) backoff(attempt int) time.Duration {
+	d := s.MinBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+
+	maxD := s.maxBackoff()
+	for i := 1; i < attempt && d < maxD; i++ {
+		d *= 2
+	}
+	if d > maxD {
+		d = maxD
+	}
+
+	return d/2 + rand.N(d/2+1)
+}
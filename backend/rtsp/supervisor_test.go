@@ -0,0 +1,48 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupervisorBackoff(t *testing.T) {
+	s := &Supervisor{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	for _, test := range []struct {
+		Attempt int
+		Max     time.Duration
+	}{
+		{Attempt: 1, Max: time.Second},
+		{Attempt: 2, Max: 2 * time.Second},
+		{Attempt: 3, Max: 4 * time.Second},
+		{Attempt: 4, Max: 8 * time.Second},
+		{Attempt: 5, Max: 10 * time.Second},
+		{Attempt: 100, Max: 10 * time.Second},
+	} {
+		for i := 0; i < 10; i++ {
+			d := s.backoff(test.Attempt)
+			require.GreaterOrEqual(t, d, test.Max/2)
+			require.LessOrEqual(t, d, test.Max)
+		}
+	}
+}
+
+func TestSupervisorStop(t *testing.T) {
+	// nobody listens on this port
+	s := NewSupervisor(NewClient("rtsp://127.0.0.1:1/stream"))
+	s.MinBackoff = time.Millisecond
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run()
+	}()
+
+	event := <-s.Events()
+	require.Equal(t, StateNone, event.State)
+	require.Error(t, event.Err)
+
+	s.Stop()
+	require.NoError(t, <-done)
+}
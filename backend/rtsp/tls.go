@@ -0,0 +1,109 @@
+package rtsp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+var ErrPinMismatch = errors.New("rtsp: server certificate doesn't match pinned keys")
+
+// TLSOptions - settings of rtsps connection. Without options server certificate
+// is verified by system CA pool, except IP hosts and rtspx:// scheme.
+type TLSOptions struct {
+	// RootCAs - trusted CA pool, system pool if nil
+	RootCAs *x509.CertPool
+
+	// Certificates - client certificates for mutual TLS
+	Certificates []tls.Certificate
+
+	// PinnedSPKI - SHA-256 hashes of SubjectPublicKeyInfo (see SPKIHash),
+	// one of verified chain certificates should match if not empty.
+	// With InsecureSkipVerify only server leaf certificate is checked.
+	PinnedSPKI [][]byte
+
+	// MinVersion - minimal TLS version, for example tls.VersionTLS13,
+	// Go default if zero
+	MinVersion uint16
+
+	// ServerName - name for certificate verification, URL hostname if empty
+	ServerName string
+
+	// InsecureSkipVerify disables chain verification, pinning still works
+	InsecureSkipVerify bool
+}
+
+// SPKIHash returns SHA-256 hash of certificate public key for pinning
+func SPKIHash(cert *x509.Certificate) []byte {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hash[:]
+}
+
+func (o *TLSOptions) config(hostname string, insecure bool) *tls.Config {
+	config := &tls.Config{
+		ServerName:         hostname,
+		RootCAs:            o.RootCAs,
+		Certificates:       o.Certificates,
+		MinVersion:         o.MinVersion,
+		InsecureSkipVerify: insecure || o.InsecureSkipVerify,
+	}
+
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+
+	if len(o.PinnedSPKI) > 0 {
+		skipVerify := config.InsecureSkipVerify
+
+		// called after chain verification, and also with InsecureSkipVerify
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			chains := state.VerifiedChains
+			if skipVerify {
+				// unverified chain is anything server sends, attacker can
+				// append real public certificate after own leaf
+				if len(state.PeerCertificates) == 0 {
+					return ErrPinMismatch
+				}
+				chains = [][]*x509.Certificate{state.PeerCertificates[:1]}
+			}
+
+			for _, chain := range chains {
+				for _, cert := range chain {
+					if o.pinned(cert) {
+						return nil
+					}
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	return config
+}
+
+func (o *TLSOptions) pinned(cert *x509.Certificate) bool {
+	hash := SPKIHash(cert)
+	for _, pin := range o.PinnedSPKI {
+		if bytes.Equal(hash, pin) {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSConfig returns config for rtsps, rtspx, rtmps and rtmpx schemes,
+// x schemes skip certificate verification
+func TLSConfig(options *TLSOptions, scheme, hostname string) *tls.Config {
+	insecure := len(scheme) == 5 && scheme[4] == 'x'
+
+	if options != nil {
+		return options.config(hostname, insecure)
+	}
+
+	if insecure || IsIP(hostname) {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+	return &tls.Config{ServerName: hostname}
+}
@@ -0,0 +1,137 @@
+package rtsp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCert returns certificate signed by parent or self-signed CA if parent is nil
+func newTestCert(t *testing.T, parent *tls.Certificate, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "phoring test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func startTLSTestServer(t *testing.T, config *tls.Config) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+
+	srv := NewServer()
+	addTestStream(srv)
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+func TestClientTLS(t *testing.T) {
+	ca := newTestCert(t, nil, 1)
+	serverCert := newTestCert(t, &ca, 2)
+	clientCert := newTestCert(t, &ca, 3)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	address := startTLSTestServer(t, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+
+	describe := func(options *TLSOptions) error {
+		client := NewClient("rtsps://" + address + "/camera1")
+		client.TLS = options
+		if err := client.Dial(); err != nil {
+			return err
+		}
+		defer client.Close()
+		return client.Describe()
+	}
+
+	require.NoError(t, describe(&TLSOptions{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+		PinnedSPKI:   [][]byte{SPKIHash(serverCert.Leaf)},
+		MinVersion:   tls.VersionTLS12,
+	}))
+
+	// pinning without chain verification
+	require.NoError(t, describe(&TLSOptions{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCert},
+		PinnedSPKI:         [][]byte{SPKIHash(serverCert.Leaf)},
+	}))
+
+	require.ErrorIs(t, describe(&TLSOptions{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+		PinnedSPKI:   [][]byte{SPKIHash(clientCert.Leaf)},
+	}), ErrPinMismatch)
+
+	// untrusted server
+	require.Error(t, describe(&TLSOptions{Certificates: []tls.Certificate{clientCert}}))
+
+	// server requires client certificate
+	require.Error(t, describe(&TLSOptions{RootCAs: pool}))
+}
+
+func TestClientTLSPinLeaf(t *testing.T) {
+	ca := newTestCert(t, nil, 1)
+	serverCert := newTestCert(t, &ca, 2)
+	attackerCert := newTestCert(t, nil, 3)
+
+	// attacker sends own leaf and public certificate of real server
+	attackerCert.Certificate = append(attackerCert.Certificate, serverCert.Certificate[0])
+	address := startTLSTestServer(t, &tls.Config{Certificates: []tls.Certificate{attackerCert}})
+
+	client := NewClient("rtsps://" + address + "/camera1")
+	client.TLS = &TLSOptions{
+		InsecureSkipVerify: true,
+		PinnedSPKI:         [][]byte{SPKIHash(serverCert.Leaf)},
+	}
+	err := client.Dial()
+	if err == nil {
+		defer client.Close()
+		err = client.Describe()
+	}
+	require.ErrorIs(t, err, ErrPinMismatch)
+}
@@ -0,0 +1,338 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TransportTCP = "tcp" // RTP/AVP/TCP;unicast;interleaved=
+	TransportUDP = "udp" // RTP/AVP;unicast;client_port=
+
+	TransportMulticast = "multicast" // RTP/AVP;multicast
+)
+
+// TransportHeader is a single transport spec from the RTSP Transport header
+// https://datatracker.ietf.org/doc/html/rfc2326#section-12.39
+type TransportHeader struct {
+	Protocol    string // RTP/AVP, RTP/AVP/TCP, RTP/AVP/UDP
+	Multicast   bool
+	Interleaved []int // RTP, RTCP channels
+	ClientPort  []int // RTP, RTCP ports
+	ServerPort  []int // RTP, RTCP ports
+	Port        []int // RTP, RTCP ports for multicast
+	Source      string
+	Destination string
+	TTL         int
+	SSRC        string
+	Mode        string
+}
+
+// ParseTransportHeader parses one transport spec, for example:
+// RTP/AVP;unicast;client_port=5000-5001;server_port=6970-6971;source=192.168.1.123
+func ParseTransportHeader(s string) (*TransportHeader, error) {
+	params := strings.Split(strings.TrimSpace(s), ";")
+	if params[0] == "" {
+		return nil, errors.New("rtsp: empty transport")
+	}
+
+	th := &TransportHeader{Protocol: params[0]}
+
+	for _, param := range params[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+		var err error
+
+		switch strings.ToLower(key) {
+		case "unicast":
+			th.Multicast = false
+		case "multicast":
+			th.Multicast = true
+		case "interleaved":
+			th.Interleaved, err = parsePair(value)
+		case "client_port":
+			th.ClientPort, err = parsePair(value)
+		case "server_port":
+			th.ServerPort, err = parsePair(value)
+		case "port":
+			th.Port, err = parsePair(value)
+		case "source":
+			th.Source = value
+		case "destination":
+			th.Destination = value
+		case "ttl":
+			th.TTL, err = strconv.Atoi(value)
+		case "ssrc":
+			th.SSRC = value
+		case "mode":
+			th.Mode = strings.Trim(value, `"`)
+		}
+
+		if err != nil {
+			return nil, errors.New("rtsp: wrong transport: " + s)
+		}
+	}
+
+	return th, nil
+}
+
+func (th *TransportHeader) String() string {
+	s := th.Protocol
+	if th.Multicast {
+		s += ";multicast"
+	} else {
+		s += ";unicast"
+	}
+	if th.Destination != "" {
+		s += ";destination=" + th.Destination
+	}
+	if th.Source != "" {
+		s += ";source=" + th.Source
+	}
+	if th.Interleaved != nil {
+		s += ";interleaved=" + formatPair(th.Interleaved)
+	}
+	if th.ClientPort != nil {
+		s += ";client_port=" + formatPair(th.ClientPort)
+	}
+	if th.ServerPort != nil {
+		s += ";server_port=" + formatPair(th.ServerPort)
+	}
+	if th.Port != nil {
+		s += ";port=" + formatPair(th.Port)
+	}
+	if th.TTL != 0 {
+		s += ";ttl=" + strconv.Itoa(th.TTL)
+	}
+	if th.SSRC != "" {
+		s += ";ssrc=" + th.SSRC
+	}
+	if th.Mode != "" {
+		s += ";mode=" + th.Mode
+	}
+	return s
+}
+
+// parsePair parses `0-1` or `0`, the second value defaults to first+1
+func parsePair(s string) ([]int, error) {
+	s1, s2, ok := strings.Cut(s, "-")
+	i1, err := strconv.Atoi(s1)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []int{i1, i1 + 1}, nil
+	}
+	i2, err := strconv.Atoi(s2)
+	if err != nil {
+		return nil, err
+	}
+	return []int{i1, i2}, nil
+}
+
+func formatPair(pair []int) string {
+	return strconv.Itoa(pair[0]) + "-" + strconv.Itoa(pair[1])
+}
+
+// ListenUDPPair allocates two sockets with an even RTP port and the next odd RTCP port
+func ListenUDPPair(ip net.IP) (rtpConn, rtcpConn *net.UDPConn, err error) {
+	for i := 0; i < 100; i++ {
+		if rtpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: ip}); err != nil {
+			return nil, nil, err
+		}
+
+		port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+		if port&1 == 1 || port == 65534 {
+			_ = rtpConn.Close()
+			continue
+		}
+
+		if rtcpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port + 1}); err == nil {
+			return rtpConn, rtcpConn, nil
+		}
+
+		_ = rtpConn.Close()
+	}
+
+	return nil, nil, errors.New("rtsp: can't allocate UDP port pair")
+}
+
+// udpConn - RTP and RTCP sockets of one media in UDP mode
+type udpConn struct {
+	channel  byte // virtual interleaved channel for RTP, channel+1 for RTCP
+	rtp      *net.UDPConn
+	rtcp     *net.UDPConn
+	rtpAddr  *net.UDPAddr // remote RTP address
+	rtcpAddr *net.UDPAddr // remote RTCP address
+	source   net.IP       // accept packets only from this IP
+}
+
+func (u *udpConn) Close() error {
+	err1 := u.rtp.Close()
+	err2 := u.rtcp.Close()
+	return errors.Join(err1, err2)
+}
+
+// punch sends empty packets to the server, so NAT and firewalls let the stream in
+func (u *udpConn) punch() {
+	if u.rtpAddr != nil {
+		// RTP version 2, no payload
+		_, _ = u.rtp.WriteToUDP([]byte{0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, u.rtpAddr)
+	}
+	if u.rtcpAddr != nil {
+		// empty RTCP receiver report
+		_, _ = u.rtcp.WriteToUDP([]byte{0x80, 201, 0, 1, 0, 0, 0, 0}, u.rtcpAddr)
+	}
+}
+
+func (c *Client) setupUDP(th *TransportHeader, u *udpConn) {
+	var ip net.IP
+	if addr, ok := c.conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	if th.Source != "" {
+		if source := net.ParseIP(th.Source); source != nil {
+			ip = source
+		}
+	}
+	u.source = ip
+
+	if th.ServerPort != nil {
+		u.rtpAddr = &net.UDPAddr{IP: ip, Port: th.ServerPort[0]}
+		u.rtcpAddr = &net.UDPAddr{IP: ip, Port: th.ServerPort[1]}
+	}
+
+	u.punch()
+
+	c.addUDP(u)
+}
+
+func (c *Client) setupMulticast(th *TransportHeader, channel byte) error {
+	group := net.ParseIP(th.Destination)
+	if group == nil || !group.IsMulticast() {
+		return fmt.Errorf("rtsp: wrong multicast destination: %s", th.Destination)
+	}
+
+	ports := th.Port
+	if ports == nil {
+		// some servers use client_port for multicast
+		ports = th.ClientPort
+	}
+	if ports == nil {
+		return errors.New("rtsp: multicast port not provided")
+	}
+
+	var ifi *net.Interface
+	if c.MulticastInterface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(c.MulticastInterface); err != nil {
+			return err
+		}
+	}
+
+	rtpConn, err := net.ListenMulticastUDP("udp", ifi, &net.UDPAddr{IP: group, Port: ports[0]})
+	if err != nil {
+		return err
+	}
+
+	rtcpConn, err := net.ListenMulticastUDP("udp", ifi, &net.UDPAddr{IP: group, Port: ports[1]})
+	if err != nil {
+		_ = rtpConn.Close()
+		return err
+	}
+
+	u := &udpConn{
+		channel:  channel,
+		rtp:      rtpConn,
+		rtcp:     rtcpConn,
+		rtcpAddr: &net.UDPAddr{IP: group, Port: ports[1]}, // receiver reports go to the group
+	}
+
+	// packets may come from another host than RTSP server, so filter only
+	// when server tells the source
+	if th.Source != "" {
+		u.source = net.ParseIP(th.Source)
+	}
+
+	c.addUDP(u)
+	return nil
+}
+
+func (c *Client) addUDP(u *udpConn) {
+	c.udpMu.Lock()
+	c.udp = append(c.udp, u)
+	c.udpMu.Unlock()
+}
+
+// udpConns returns UDP sockets, safe for use with concurrent closeUDP
+func (c *Client) udpConns() []*udpConn {
+	c.udpMu.Lock()
+	defer c.udpMu.Unlock()
+	return c.udp
+}
+
+func (c *Client) hasUDP() bool {
+	return c.udpConns() != nil
+}
+
+func (c *Client) closeUDP() {
+	c.udpMu.Lock()
+	udp := c.udp
+	c.udp = nil
+	c.udpMu.Unlock()
+
+	for _, u := range udp {
+		_ = u.Close()
+	}
+}
+
+func (c *Client) startUDP() {
+	c.lastUDP.Store(time.Now().UnixNano())
+
+	for _, u := range c.udpConns() {
+		go c.readUDP(u.rtp, u.channel, u.source)
+		go c.readUDP(u.rtcp, u.channel+1, u.source)
+	}
+}
+
+func (c *Client) readUDP(conn *net.UDPConn, channelID byte, source net.IP) {
+	buf := make([]byte, BufferSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return // socket closed
+		}
+
+		if source != nil && !addr.IP.Equal(source) {
+			continue
+		}
+
+		c.lastUDP.Store(time.Now().UnixNano())
+
+		// UDP packets are processed one by one, so skip broken packets
+		if err = c.handleData(channelID, append([]byte(nil), buf[:n]...)); err == ErrGoodbye {
+			// wake up Handle, it is waiting on control connection
+			c.goodbye.Store(true)
+			c.connMu.Lock()
+			if c.conn != nil {
+				_ = c.conn.SetReadDeadline(time.Now())
+			}
+			c.connMu.Unlock()
+			return
+		}
+	}
+}
+
+// udpIdle returns time since last UDP packet
+func (c *Client) udpIdle() time.Duration {
+	return time.Since(time.Unix(0, c.lastUDP.Load()))
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
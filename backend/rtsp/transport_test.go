@@ -0,0 +1,115 @@
+package rtsp
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTransportHeader(t *testing.T) {
+	for _, test := range []struct {
+		Name string
+		In   string
+		Want TransportHeader
+	}{
+		{
+			Name: "tcp",
+			In:   "RTP/AVP/TCP;unicast;interleaved=10-11;ssrc=10117CB7",
+			Want: TransportHeader{Protocol: "RTP/AVP/TCP", Interleaved: []int{10, 11}, SSRC: "10117CB7"},
+		},
+		{
+			Name: "tcp single channel",
+			In:   "RTP/AVP/TCP;unicast;destination=192.168.1.111;source=192.168.1.222;interleaved=0",
+			Want: TransportHeader{
+				Protocol: "RTP/AVP/TCP", Interleaved: []int{0, 1},
+				Source: "192.168.1.222", Destination: "192.168.1.111",
+			},
+		},
+		{
+			Name: "escam",
+			In:   "RTP/AVP;unicast;destination=192.168.1.111;source=192.168.1.222;interleaved=0-1",
+			Want: TransportHeader{
+				Protocol: "RTP/AVP", Interleaved: []int{0, 1},
+				Source: "192.168.1.222", Destination: "192.168.1.111",
+			},
+		},
+		{
+			Name: "udp",
+			In:   "RTP/AVP;unicast;client_port=5000-5001;server_port=6970-6971;source=192.168.1.222",
+			Want: TransportHeader{
+				Protocol: "RTP/AVP", ClientPort: []int{5000, 5001}, ServerPort: []int{6970, 6971},
+				Source: "192.168.1.222",
+			},
+		},
+		{
+			Name: "multicast",
+			In:   "RTP/AVP;multicast;destination=239.0.0.1;port=5000-5001;ttl=16",
+			Want: TransportHeader{
+				Protocol: "RTP/AVP", Multicast: true, Destination: "239.0.0.1",
+				Port: []int{5000, 5001}, TTL: 16,
+			},
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			th, err := ParseTransportHeader(test.In)
+			require.NoError(t, err)
+			require.Equal(t, test.Want, *th)
+		})
+	}
+
+	_, err := ParseTransportHeader("RTP/AVP;unicast;client_port=abc")
+	require.Error(t, err)
+}
+
+func TestListenUDPPair(t *testing.T) {
+	rtpConn, rtcpConn, err := ListenUDPPair(net.IPv4(127, 0, 0, 1))
+	require.NoError(t, err)
+	defer rtpConn.Close()
+	defer rtcpConn.Close()
+
+	port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+	require.Zero(t, port&1)
+	require.Equal(t, port+1, rtcpConn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestSetupMulticastDestination(t *testing.T) {
+	c := &Client{}
+
+	err := c.setupMulticast(&TransportHeader{Multicast: true, Destination: "192.168.1.1", Port: []int{5000, 5001}}, 0)
+	require.Error(t, err)
+
+	err = c.setupMulticast(&TransportHeader{Multicast: true, Destination: "239.0.0.1"}, 0)
+	require.Error(t, err)
+	require.Nil(t, c.udp)
+}
+
+func TestPassiveConsumerUDPIdle(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+
+	rtpConn, rtcpConn, err := ListenUDPPair(nil)
+	require.NoError(t, err)
+
+	// player gets RTP by UDP and sends no RTCP
+	c := &Client{conn: conn, reader: bufio.NewReader(conn), mode: ModePassiveConsumer, Timeout: 1}
+	c.addUDP(&udpConn{rtp: rtpConn, rtcp: rtcpConn})
+	c.setState(StatePlay)
+	defer c.closeUDP()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Handle()
+	}()
+
+	select {
+	case err = <-done:
+		t.Fatalf("session closed: %v", err)
+	case <-time.After(2500 * time.Millisecond):
+	}
+
+	_ = conn.Close()
+	require.Error(t, <-done)
+}
@@ -0,0 +1,130 @@
+package rtsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// tunnelConn - RTSP-over-HTTP tunnel (Apple QuickTime). Server to client data
+// comes from HTTP GET response, client to server data goes base64 encoded
+// inside HTTP POST body.
+// https://opensource.apple.com/source/QuickTimeStreamingServer/QuickTimeStreamingServer-412.42/Documentation/RTSP_Over_HTTP.pdf
+type tunnelConn struct {
+	get    net.Conn
+	post   net.Conn
+	reader *bufio.Reader
+}
+
+// DialTunnel opens GET and POST channels with the same x-sessioncookie
+func DialTunnel(address string, u *url.URL, timeout time.Duration) (net.Conn, error) {
+	return dialTunnel(context.Background(), &net.Dialer{Timeout: timeout}, address, u, timeout)
+}
+
+func dialTunnel(ctx context.Context, dialer Dialer, address string, u *url.URL, timeout time.Duration) (net.Conn, error) {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(rand.Uint32())
+	}
+	cookie := hex.EncodeToString(b)
+
+	get, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = get.SetDeadline(time.Now().Add(timeout))
+
+	req := "GET " + u.RequestURI() + " HTTP/1.0" + EndLine +
+		"Host: " + u.Host + EndLine +
+		"x-sessioncookie: " + cookie + EndLine +
+		"Accept: application/x-rtsp-tunnelled" + EndLine +
+		"Pragma: no-cache" + EndLine +
+		"Cache-Control: no-cache" + EndLine + EndLine
+	if _, err = get.Write([]byte(req)); err != nil {
+		_ = get.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReaderSize(get, BufferSize)
+
+	// body is not used, tunnelled data is read directly from reader
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		_ = get.Close()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = get.Close()
+		return nil, errors.New("rtsp: wrong tunnel response: " + res.Status)
+	}
+
+	_ = get.SetDeadline(time.Time{})
+
+	post, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		_ = get.Close()
+		return nil, err
+	}
+
+	// server doesn't answer on POST request
+	req = "POST " + u.RequestURI() + " HTTP/1.0" + EndLine +
+		"Host: " + u.Host + EndLine +
+		"x-sessioncookie: " + cookie + EndLine +
+		"Content-Type: application/x-rtsp-tunnelled" + EndLine +
+		"Pragma: no-cache" + EndLine +
+		"Cache-Control: no-cache" + EndLine +
+		"Content-Length: 32767" + EndLine +
+		"Expires: Sun, 9 Jan 1972 00:00:00 GMT" + EndLine + EndLine
+	_ = post.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err = post.Write([]byte(req)); err != nil {
+		_ = get.Close()
+		_ = post.Close()
+		return nil, err
+	}
+
+	return &tunnelConn{get: get, post: post, reader: reader}, nil
+}
+
+func (t *tunnelConn) Read(b []byte) (int, error) {
+	return t.reader.Read(b)
+}
+
+// Write encodes every message separately, same as FFmpeg and VLC do
+func (t *tunnelConn) Write(b []byte) (int, error) {
+	if _, err := t.post.Write([]byte(base64.StdEncoding.EncodeToString(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (t *tunnelConn) Close() error {
+	return errors.Join(t.get.Close(), t.post.Close())
+}
+
+func (t *tunnelConn) LocalAddr() net.Addr {
+	return t.get.LocalAddr()
+}
+
+func (t *tunnelConn) RemoteAddr() net.Addr {
+	return t.get.RemoteAddr()
+}
+
+func (t *tunnelConn) SetDeadline(deadline time.Time) error {
+	return errors.Join(t.get.SetDeadline(deadline), t.post.SetDeadline(deadline))
+}
+
+func (t *tunnelConn) SetReadDeadline(deadline time.Time) error {
+	return t.get.SetReadDeadline(deadline)
+}
+
+func (t *tunnelConn) SetWriteDeadline(deadline time.Time) error {
+	return t.post.SetWriteDeadline(deadline)
+}
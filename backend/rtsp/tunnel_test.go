@@ -0,0 +1,126 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startTestTunnel accepts GET/POST pairs and bridges them to RTSP server
+func startTestTunnel(t *testing.T, backend string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	var mu sync.Mutex
+	gets := map[string]net.Conn{}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+
+				cookie := req.Header.Get("x-sessioncookie")
+
+				if req.Method == "GET" {
+					_, _ = conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Type: application/x-rtsp-tunnelled\r\n\r\n"))
+					mu.Lock()
+					gets[cookie] = conn
+					mu.Unlock()
+					return
+				}
+
+				mu.Lock()
+				get := gets[cookie]
+				mu.Unlock()
+
+				rtspConn, err := net.Dial("tcp", backend)
+				if err != nil {
+					return
+				}
+
+				go func() {
+					_, _ = io.Copy(get, rtspConn)
+				}()
+
+				// decode every 4 bytes group, because messages are padded separately
+				buf := make([]byte, 4)
+				out := make([]byte, 3)
+				for {
+					if _, err = io.ReadFull(reader, buf); err != nil {
+						return
+					}
+					n, err := base64.StdEncoding.Decode(out, buf)
+					if err != nil {
+						return
+					}
+					if _, err = rtspConn.Write(out[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestClientTunnel(t *testing.T) {
+	srv, address := startTestServer(t)
+	srv.AddStream("camera1", &Media{
+		Kind: KindVideo, Codecs: []*Codec{{Name: CodecH264, ClockRate: 90000, PayloadType: 96}},
+	})
+
+	tunnel := startTestTunnel(t, address)
+
+	client := NewClient("rtsp+http://" + tunnel + "/camera1")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	require.Equal(t, "rtsp", client.URL.Scheme)
+	require.NoError(t, client.Describe())
+	require.Len(t, client.Medias, 1)
+
+	_, err := client.SetupMedia(client.Medias[0])
+	require.NoError(t, err)
+}
+
+func TestClientTunnelRedirect(t *testing.T) {
+	backend := startFakeServer(t, func(c *Client, req *Request) bool {
+		res := NewResponse(req, OK)
+		if req.URL.Path == "/old" {
+			res = NewResponse(req, MovedTemporarily)
+			res.Header.Set("Location", "/camera1")
+		}
+		return c.WriteResponse(res) == nil
+	})
+
+	tunnel := startTestTunnel(t, backend)
+
+	client := NewClient("rtsp+http://" + tunnel + "/old")
+	require.NoError(t, client.Dial())
+	defer client.Close()
+
+	// relative redirect keeps the tunnel
+	require.NoError(t, client.Options())
+	require.True(t, strings.HasPrefix(client.uri, "rtsp+http://"), client.uri)
+	require.Equal(t, "/camera1", client.URL.Path)
+}